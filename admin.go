@@ -0,0 +1,120 @@
+// Copyright (c) 2025 Dakhil Y.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// registerAdminAPI adds the target management endpoints. They are only
+// enabled when web.auth is configured.
+func registerAdminAPI(mux *http.ServeMux, cfg AuthConfig) {
+	if !cfg.Enabled() {
+		fmt.Println("Admin API disabled: web.auth is not configured")
+		return
+	}
+
+	auth := func(h http.HandlerFunc) http.Handler {
+		return requireAuth(cfg, h)
+	}
+
+	mux.Handle("POST /api/v1/targets", auth(handleCreateTarget))
+	mux.Handle("PUT /api/v1/targets/{target}", auth(handleUpdateTarget))
+	mux.Handle("DELETE /api/v1/targets/{target}", auth(handleDeleteTarget))
+	mux.Handle("POST /api/v1/targets/{target}/pause", auth(handlePauseTarget(true)))
+	mux.Handle("POST /api/v1/targets/{target}/resume", auth(handlePauseTarget(false)))
+}
+
+func handleCreateTarget(w http.ResponseWriter, r *http.Request) {
+	var t Target
+	if err := decodeJSON(r, &t); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	t, err := targets.add(t)
+	if err != nil {
+		writeTargetError(w, err)
+		return
+	}
+	st := targetStatus(t.Name)
+	writeJSON(w, http.StatusCreated, st)
+}
+
+func handleUpdateTarget(w http.ResponseWriter, r *http.Request) {
+	var t Target
+	if err := decodeJSON(r, &t); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	key := r.PathValue("target")
+	if err := targets.update(key, t); err != nil {
+		writeTargetError(w, err)
+		return
+	}
+	st := targetStatus(key)
+	writeJSON(w, http.StatusOK, st)
+}
+
+func handleDeleteTarget(w http.ResponseWriter, r *http.Request) {
+	if err := targets.remove(r.PathValue("target")); err != nil {
+		writeTargetError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handlePauseTarget(paused bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.PathValue("target")
+		if err := targets.setPaused(key, paused); err != nil {
+			writeTargetError(w, err)
+			return
+		}
+		st := targetStatus(key)
+		writeJSON(w, http.StatusOK, st)
+	}
+}
+
+// targetStatus describes a target after a change. Fan-out targets have no
+// results of their own and are described by their settings only.
+func targetStatus(key string) TargetStatus {
+	if st, ok := results.status(key); ok {
+		return st
+	}
+	t, _ := targets.get(key)
+	return TargetStatus{
+		Target:   t.Name,
+		URL:      targets.naming.redact(t.URL),
+		Labels:   t.Labels,
+		Interval: t.Interval.String(),
+		Paused:   t.Paused,
+	}
+}
+
+func decodeJSON(r *http.Request, v any) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("invalid request body: %w", err)
+	}
+	return nil
+}
+
+// writeTargetError maps scheduler errors to status codes. Anything not
+// recognised is a validation failure.
+func writeTargetError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrTargetNotFound):
+		writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, ErrTargetExists), errors.Is(err, ErrTargetReadOnly):
+		writeError(w, http.StatusConflict, err)
+	case errors.Is(err, ErrStateSave):
+		writeError(w, http.StatusInternalServerError, err)
+	default:
+		writeError(w, http.StatusBadRequest, err)
+	}
+}
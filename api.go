@@ -0,0 +1,254 @@
+// Copyright (c) 2025 Dakhil Y.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultPageLimit = 100
+	MaxPageLimit     = 1000
+)
+
+// Page wraps a paginated list response.
+type Page struct {
+	Items  any `json:"items"`
+	Total  int `json:"total"`
+	Offset int `json:"offset"`
+	Limit  int `json:"limit"`
+}
+
+// ErrorSummary is the error reason breakdown over a time range.
+type ErrorSummary struct {
+	Total   int            `json:"total"`
+	Reasons map[string]int `json:"reasons"`
+}
+
+// registerAPI adds the query endpoints. They show target URLs and labels,
+// which public mode hides from the status page, so with a public status page
+// they require web.auth and are disabled when it is not configured.
+func registerAPI(mux *http.ServeMux, cfg AuthConfig, public bool) {
+	if public && !cfg.Enabled() {
+		fmt.Println("Query API disabled: the status page is public and web.auth is not configured")
+		return
+	}
+
+	handle := func(pattern string, h http.HandlerFunc) {
+		if public {
+			mux.Handle(pattern, requireAuth(cfg, h))
+			return
+		}
+		mux.Handle(pattern, h)
+	}
+
+	handle("GET /api/v1/targets", handleListTargets)
+	handle("GET /api/v1/targets/{target}", handleGetTarget)
+	handle("GET /api/v1/targets/{target}/results", handleTargetResults)
+	handle("GET /api/v1/targets/{target}/summary", handleTargetSummary)
+	handle("GET /api/v1/targets/{target}/errors", handleTargetErrors)
+	handle("GET /api/v1/errors", handleErrors)
+}
+
+func handleListTargets(w http.ResponseWriter, r *http.Request) {
+	selector, err := parseSelector(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	offset, limit, err := parsePage(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	all := results.statuses(selector)
+	writeJSON(w, http.StatusOK, Page{
+		Items:  paginate(all, offset, limit),
+		Total:  len(all),
+		Offset: offset,
+		Limit:  limit,
+	})
+}
+
+func handleGetTarget(w http.ResponseWriter, r *http.Request) {
+	st, ok := results.status(r.PathValue("target"))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown target %q", r.PathValue("target")))
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+func handleTargetResults(w http.ResponseWriter, r *http.Request) {
+	offset, limit, err := parsePage(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	from, to, err := parseRange(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	rs, ok := results.between(r.PathValue("target"), from, to)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown target %q", r.PathValue("target")))
+		return
+	}
+	writeJSON(w, http.StatusOK, Page{
+		Items:  paginate(rs, offset, limit),
+		Total:  len(rs),
+		Offset: offset,
+		Limit:  limit,
+	})
+}
+
+func handleTargetSummary(w http.ResponseWriter, r *http.Request) {
+	from, to, err := parseRange(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	rs, ok := results.between(r.PathValue("target"), from, to)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown target %q", r.PathValue("target")))
+		return
+	}
+	writeJSON(w, http.StatusOK, summarize(rs))
+}
+
+func handleTargetErrors(w http.ResponseWriter, r *http.Request) {
+	from, to, err := parseRange(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	rs, ok := results.between(r.PathValue("target"), from, to)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown target %q", r.PathValue("target")))
+		return
+	}
+	writeJSON(w, http.StatusOK, newErrorSummary(rs))
+}
+
+// handleErrors aggregates error reasons across every target matching the
+// label selector.
+func handleErrors(w http.ResponseWriter, r *http.Request) {
+	selector, err := parseSelector(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	from, to, err := parseRange(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var all []Result
+	for _, st := range results.statuses(selector) {
+		rs, _ := results.between(st.Target, from, to)
+		all = append(all, rs...)
+	}
+	writeJSON(w, http.StatusOK, newErrorSummary(all))
+}
+
+func newErrorSummary(rs []Result) ErrorSummary {
+	reasons := errorBreakdown(rs)
+	total := 0
+	for _, n := range reasons {
+		total += n
+	}
+	return ErrorSummary{Total: total, Reasons: reasons}
+}
+
+// parseSelector reads repeated label=key=value query parameters.
+func parseSelector(r *http.Request) (map[string]string, error) {
+	selector := make(map[string]string)
+	for _, l := range r.URL.Query()["label"] {
+		k, v, ok := strings.Cut(l, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid label selector %q, want key=value", l)
+		}
+		selector[k] = v
+	}
+	return selector, nil
+}
+
+func parsePage(r *http.Request) (offset, limit int, err error) {
+	q := r.URL.Query()
+	limit = DefaultPageLimit
+
+	if v := q.Get("offset"); v != "" {
+		offset, err = strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("invalid offset %q", v)
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return 0, 0, fmt.Errorf("invalid limit %q", v)
+		}
+	}
+	if limit > MaxPageLimit {
+		limit = MaxPageLimit
+	}
+	return offset, limit, nil
+}
+
+// parseRange reads from/to as RFC 3339 timestamps, or window as a duration
+// ending now. Missing bounds are left zero.
+func parseRange(r *http.Request) (from, to time.Time, err error) {
+	q := r.URL.Query()
+
+	if v := q.Get("window"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return from, to, fmt.Errorf("invalid window %q", v)
+		}
+		from = time.Now().Add(-d)
+	}
+	if v := q.Get("from"); v != "" {
+		from, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return from, to, fmt.Errorf("invalid from %q: %w", v, err)
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		to, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return from, to, fmt.Errorf("invalid to %q: %w", v, err)
+		}
+	}
+	return from, to, nil
+}
+
+func paginate[T any](items []T, offset, limit int) []T {
+	if offset >= len(items) {
+		return []T{}
+	}
+	end := offset + limit
+	if end > len(items) {
+		end = len(items)
+	}
+	return items[offset:end]
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2025 Dakhil Y.
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// DefaultMaxBodySize is how much of a response body HTTP probes read unless
+// http.max_body_size says otherwise.
+const DefaultMaxBodySize = 10 << 20
+
+// acceptEncoding is sent by HTTP probes, so that the transport leaves bodies
+// compressed and their size on the wire can be measured.
+const acceptEncoding = "gzip, deflate"
+
+// Body describes a response body as an HTTP probe read it. Bytes is its size
+// as received, DecompressedBytes its size after undoing the content
+// encoding, and Throughput the rate it arrived at after the headers, in
+// bytes per second. Truncated bodies were cut off at the size limit and
+// are neither decompressed nor checked against their Content-Length.
+type Body struct {
+	Bytes             int64   `json:"bytes"`
+	DecompressedBytes int64   `json:"decompressed_bytes,omitempty"`
+	Encoding          string  `json:"encoding"`
+	Throughput        float64 `json:"throughput_bytes_per_second,omitempty"`
+	Truncated         bool    `json:"truncated,omitempty"`
+}
+
+var (
+	errContentLength = errors.New("body length does not match Content-Length")
+	errDecode        = errors.New("cannot decode body")
+)
+
+// countingReader counts the bytes read through it and keeps the first
+// error other than io.EOF.
+type countingReader struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if err != nil && err != io.EOF && c.err == nil {
+		c.err = err
+	}
+	return n, err
+}
+
+// readBody reads up to limit bytes of a response body and decompresses them
+// by their content encoding. Encodings other than gzip and deflate are not
+// decompressed.
+func readBody(resp *http.Response, limit int64) (*Body, error) {
+	body := &Body{Encoding: strings.ToLower(resp.Header.Get("Content-Encoding"))}
+	if body.Encoding == "" {
+		body.Encoding = "identity"
+	}
+
+	raw := &countingReader{r: io.LimitReader(resp.Body, limit+1)}
+	var decoded io.Reader
+	var err error
+	switch body.Encoding {
+	case "identity":
+		decoded = raw
+	case "gzip", "x-gzip":
+		decoded, err = gzip.NewReader(raw)
+	case "deflate":
+		decoded, err = zlib.NewReader(raw)
+	}
+	var n int64
+	if err == nil && decoded != nil {
+		n, err = io.Copy(io.Discard, decoded)
+	}
+	io.Copy(io.Discard, raw)
+
+	body.Bytes = min(raw.n, limit)
+	body.Truncated = raw.n > limit
+	switch {
+	case raw.err != nil:
+		return body, raw.err
+	case body.Truncated:
+		return body, nil
+	case resp.ContentLength >= 0 && raw.n != resp.ContentLength:
+		return body, fmt.Errorf("%w: got %d bytes, expected %d", errContentLength, raw.n, resp.ContentLength)
+	case err != nil && raw.n > 0:
+		return body, fmt.Errorf("%w: %w", errDecode, err)
+	case decoded != nil:
+		body.DecompressedBytes = n
+	}
+	return body, nil
+}
+
+// classifyBodyError classifies a failure to read a response body. A
+// connection closing before Content-Length bytes arrived counts as a
+// length mismatch.
+func classifyBodyError(err error) string {
+	switch reason := classifyTransportError(err); {
+	case errors.Is(err, errContentLength), errors.Is(err, io.ErrUnexpectedEOF):
+		return FailureContentLengthMismatch
+	case reason == FailureTimeout, reason == FailureContextDeadline, reason == FailureContextCanceled:
+		return reason
+	}
+	return FailureBodyRead
+}
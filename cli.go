@@ -0,0 +1,413 @@
+// Copyright (c) 2025 Dakhil Y.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const usage = `Usage: netpulse <command> [flags]
+
+Commands:
+  serve                 Run the prober daemon (default)
+  probe <url>           Probe a URL once and print phase timings
+  validate <config>     Check a config file
+  targets list          List targets of a running instance
+
+Run 'netpulse <command> -h' for command flags.
+`
+
+// run dispatches a subcommand and returns the process exit code.
+func run(args []string) int {
+	if len(args) == 0 {
+		return runServe(nil)
+	}
+
+	switch args[0] {
+	case "serve":
+		return runServe(args[1:])
+	case "probe":
+		return runProbe(args[1:])
+	case "validate":
+		return runValidate(args[1:])
+	case "targets":
+		return runTargets(args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", args[0], usage)
+		return 2
+	}
+}
+
+func runServe(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	path := fs.String("config", envOr("NETPULSE_CONFIG", DefaultConfigFile), "config file")
+	public := fs.Bool("public-status", os.Getenv("NETPULSE_STATUS_PUBLIC") == "true", "serve the status page in public mode")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg, err := LoadConfig(*path)
+	if err != nil {
+		fmt.Printf("Error loading config %s: %v\n", *path, err)
+		return 1
+	}
+
+	listeners, err := listen(cfg.Web)
+	if err != nil {
+		fmt.Printf("Error starting listener: %v\n", err)
+		return 1
+	}
+
+	initMetrics(cfg.TargetLabels, cfg.Cardinality)
+
+	mux := http.NewServeMux()
+	var metrics http.Handler = promhttp.Handler()
+	if cfg.Web.Auth.Enabled() {
+		metrics = requireAuth(cfg.Web.Auth, metrics)
+	}
+	mux.Handle("/metrics", metrics)
+	registerAPI(mux, cfg.Web.Auth, *public)
+	registerAdminAPI(mux, cfg.Web.Auth)
+	registerStatusPage(mux, *public)
+	if cfg.Web.ThroughputEndpoint {
+		registerThroughputEndpoint(mux, cfg.Web.Auth)
+	}
+
+	targets = newScheduler(cfg.StateFile, cfg.TargetNames)
+	if err := targets.load(cfg); err != nil {
+		fmt.Printf("Error loading state: %v\n", err)
+		return 1
+	}
+	ds, err := cfg.discoverers()
+	if err != nil {
+		fmt.Printf("Error starting discovery: %v\n", err)
+		return 1
+	}
+	startDiscovery(context.Background(), ds, targets)
+
+	for _, l := range listeners {
+		fmt.Printf("Listening on %s\n", l.Addr())
+	}
+	if err := serve(listeners, mux); err != nil {
+		fmt.Printf("Error serving: %v\n", err)
+	}
+	return 1
+}
+
+// runProbe probes a URL once. The exit code is 0 on success and 1 on any
+// transport or HTTP error.
+func runProbe(args []string) int {
+	fs := flag.NewFlagSet("probe", flag.ContinueOnError)
+	timeout := fs.Duration("timeout", httpClient.Timeout, "probe timeout")
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: netpulse probe [flags] <url>")
+		return 2
+	}
+
+	t := Target{URL: fs.Arg(0)}
+	t.applyDefaults(NamingConfig{})
+	if err := t.validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	httpClient.Timeout = *timeout
+	run, closeProber := newProber(t)
+	res, err := run(context.Background())
+	closeProber()
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(res)
+	} else {
+		printProbe(os.Stdout, res, err)
+	}
+
+	if !res.Success() {
+		return 1
+	}
+	return 0
+}
+
+func printProbe(w io.Writer, res Result, err error) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Target:\t%s\n", res.Target)
+	fmt.Fprintf(tw, "Status:\t%s\n", res.Status)
+	fmt.Fprintf(tw, "Error reason:\t%s\n", res.ErrorReason)
+	if err != nil {
+		fmt.Fprintf(tw, "Error:\t%v\n", err)
+	}
+	if res.Code != 0 {
+		fmt.Fprintf(tw, "Code:\t%d\n", res.Code)
+	}
+	if p := res.Phases; p != nil {
+		fmt.Fprintf(tw, "DNS:\t%s\n", seconds(p.DNS))
+		fmt.Fprintf(tw, "Connect:\t%s\n", seconds(p.Connect))
+		fmt.Fprintf(tw, "TLS:\t%s\n", seconds(p.TLS))
+		fmt.Fprintf(tw, "First byte:\t%s\n", seconds(p.FirstByte))
+		fmt.Fprintf(tw, "Transfer:\t%s\n", seconds(p.Transfer))
+	}
+	if res.IPVersion != "" {
+		family := res.IPVersion
+		if res.Fallback {
+			family += " (fallback)"
+		}
+		fmt.Fprintf(tw, "Connected over:\t%s\n", family)
+	}
+	if res.Protocol != "" {
+		fmt.Fprintf(tw, "Protocol:\t%s\n", res.Protocol)
+	}
+	if res.Connection != "" {
+		fmt.Fprintf(tw, "Connection:\t%s\n", res.Connection)
+	}
+	if b := res.Body; b != nil {
+		size := fmt.Sprintf("%d bytes %s", b.Bytes, b.Encoding)
+		if b.DecompressedBytes > 0 && b.Encoding != "identity" {
+			size += fmt.Sprintf(", %d decompressed", b.DecompressedBytes)
+		}
+		if b.Truncated {
+			size += ", truncated"
+		}
+		fmt.Fprintf(tw, "Body:\t%s\n", size)
+	}
+	if tr := res.Transfer; tr != nil {
+		fmt.Fprintf(tw, "%s:\t%d bytes in %s, %.1f Mbit/s\n", strings.ToUpper(tr.Direction[:1])+tr.Direction[1:],
+			tr.Bytes, seconds(tr.Seconds), tr.BitsPerSecond/1e6)
+	}
+	if res.Attempts > 0 {
+		fmt.Fprintf(tw, "Lost:\t%d/%d\n", res.Lost, res.Attempts)
+	}
+	for _, s := range res.Steps {
+		fmt.Fprintf(tw, "Step %s:\t%s\n", s.Name, seconds(s.Latency))
+	}
+	if res.FailedStep != "" {
+		fmt.Fprintf(tw, "Failed step:\t%s\n", res.FailedStep)
+	}
+	if c := res.TLS; c != nil {
+		fmt.Fprintf(tw, "TLS:\t%s %s\n", c.Version, c.CipherSuite)
+		fmt.Fprintf(tw, "Certificate:\t%s, issued by %s, expires %s\n",
+			c.Subject, c.Issuer, c.NotAfter.Format(time.RFC3339))
+	}
+	if res.MTU > 0 {
+		fmt.Fprintf(tw, "Path MTU:\t%d\n", res.MTU)
+	}
+	for _, h := range res.Hops {
+		addr := h.Address
+		if addr == "" {
+			addr = "*"
+		}
+		fmt.Fprintf(tw, "Hop %d:\t%s\t%s\tlost %d/%d\n", h.TTL, addr, seconds(h.Latency), h.Lost, h.Sent)
+	}
+	fmt.Fprintf(tw, "Total:\t%s\n", seconds(res.Latency))
+	if res.ReusedLatency > 0 {
+		fmt.Fprintf(tw, "Reused connection:\t%s\n", seconds(res.ReusedLatency))
+	}
+	tw.Flush()
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second)).Round(time.Microsecond)
+}
+
+func runValidate(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: netpulse validate <config>")
+		return 2
+	}
+
+	cfg, err := LoadConfig(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s is invalid:\n%v\n", fs.Arg(0), err)
+		return 1
+	}
+	fmt.Printf("%s is valid: %d targets\n", fs.Arg(0), len(cfg.Targets))
+	return 0
+}
+
+func runTargets(args []string) int {
+	if len(args) == 0 || args[0] != "list" {
+		fmt.Fprintln(os.Stderr, "usage: netpulse targets list [flags]")
+		return 2
+	}
+
+	fs := flag.NewFlagSet("targets list", flag.ContinueOnError)
+	addr := fs.String("addr", envOr("NETPULSE_ADDR", "http://localhost:8080"), "address of a running netpulse")
+	var labels labelFlag
+	fs.Var(&labels, "label", "filter by label key=value, may be repeated")
+	username := fs.String("username", os.Getenv("NETPULSE_USERNAME"), "web.auth username")
+	passwordFile := fs.String("password-file", os.Getenv("NETPULSE_PASSWORD_FILE"), "file holding the web.auth password")
+	tokenFile := fs.String("bearer-token-file", os.Getenv("NETPULSE_BEARER_TOKEN_FILE"),
+		"file holding the web.auth bearer token")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	auth, err := clientAuth(*username, *passwordFile, *tokenFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	statuses, err := fetchTargets(*addr, labels, auth)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TARGET\tSTATE\tLATENCY\tSUCCESS\tLAST ERROR\tLABELS")
+	for _, st := range statuses {
+		state, latency, reason := "unknown", "-", "-"
+		if st.Paused {
+			state = "paused"
+		} else if st.LastResult != nil {
+			state = "down"
+			if st.Up {
+				state = "up"
+			}
+		}
+		if st.LastResult != nil {
+			latency = seconds(st.LastResult.Latency).String()
+			reason = st.LastResult.ErrorReason
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%.1f%%\t%s\t%s\n",
+			st.Target, state, latency, st.SuccessRatio*100, reason, formatLabels(st.Labels))
+	}
+	tw.Flush()
+	return 0
+}
+
+// clientAuth reads the credentials sent to a running instance. Secrets are
+// read from files so they do not show up in the process list.
+func clientAuth(username, passwordFile, tokenFile string) (AuthConfig, error) {
+	var auth AuthConfig
+	if passwordFile != "" && username == "" {
+		return auth, errors.New("-password-file requires -username")
+	}
+	auth.Username = username
+	if passwordFile != "" {
+		password, err := readSecretFile(passwordFile)
+		if err != nil {
+			return auth, fmt.Errorf("read password: %w", err)
+		}
+		auth.Password = password
+	}
+	if tokenFile != "" {
+		token, err := readSecretFile(tokenFile)
+		if err != nil {
+			return auth, fmt.Errorf("read bearer token: %w", err)
+		}
+		auth.BearerToken = token
+	}
+	return auth, nil
+}
+
+// fetchTargets pages through the target list of a running instance.
+func fetchTargets(addr string, labels labelFlag, auth AuthConfig) ([]TargetStatus, error) {
+	var out []TargetStatus
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	for offset := 0; ; {
+		q := url.Values{}
+		q.Set("offset", fmt.Sprint(offset))
+		q.Set("limit", fmt.Sprint(MaxPageLimit))
+		for _, l := range labels {
+			q.Add("label", l)
+		}
+
+		req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(addr, "/")+"/api/v1/targets?"+q.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case auth.BearerToken != "":
+			req.Header.Set("Authorization", "Bearer "+auth.BearerToken)
+		case auth.Username != "":
+			req.SetBasicAuth(auth.Username, auth.Password)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		var page struct {
+			Items []TargetStatus `json:"items"`
+			Total int            `json:"total"`
+			Error string         `json:"error"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("decode response: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%s: %s", resp.Status, page.Error)
+		}
+
+		out = append(out, page.Items...)
+		offset += len(page.Items)
+		if len(page.Items) == 0 || offset >= page.Total {
+			return out, nil
+		}
+	}
+}
+
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return "-"
+	}
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// labelFlag collects repeated -label key=value flags.
+type labelFlag []string
+
+func (l *labelFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *labelFlag) Set(v string) error {
+	if k, _, ok := strings.Cut(v, "="); !ok || k == "" {
+		return fmt.Errorf("invalid label %q, want key=value", v)
+	}
+	*l = append(*l, v)
+	return nil
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
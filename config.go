@@ -0,0 +1,478 @@
+// Copyright (c) 2025 Dakhil Y.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"go.yaml.in/yaml/v2"
+)
+
+const (
+	DefaultListenAddress = ":8080"
+	DefaultConfigFile    = "netpulse.yml"
+	DefaultStateFile     = "netpulse-state.json"
+	DefaultInterval      = 500 * time.Millisecond
+	MinInterval          = 100 * time.Millisecond
+	MaxNameLength        = 256
+)
+
+// Config is the netpulse configuration file.
+type Config struct {
+	Web          WebConfig         `yaml:"web"`
+	StateFile    string            `yaml:"state_file"`
+	TargetLabels []string          `yaml:"target_labels"`
+	TargetNames  NamingConfig      `yaml:"target_names"`
+	Cardinality  CardinalityConfig `yaml:"cardinality"`
+	Targets      []Target          `yaml:"targets"`
+
+	FileSDConfigs   []FileSDConfig       `yaml:"file_sd_configs"`
+	DNSSDConfigs    []DNSSDConfig        `yaml:"dns_sd_configs"`
+	KubeSDConfigs   []KubernetesSDConfig `yaml:"kubernetes_sd_configs"`
+	ConsulSDConfigs []ConsulSDConfig     `yaml:"consul_sd_configs"`
+}
+
+// WebConfig controls the HTTP listeners serving metrics, the APIs and the
+// status page.
+type WebConfig struct {
+	Listen []string   `yaml:"listen"`
+	TLS    *TLSConfig `yaml:"tls"`
+	Auth   AuthConfig `yaml:"auth"`
+
+	// ThroughputEndpoint serves payloads for the throughput probes of other
+	// netpulse instances.
+	ThroughputEndpoint bool `yaml:"throughput_endpoint"`
+}
+
+// TLSConfig enables HTTPS. The files are reloaded when they change on disk.
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+// AuthConfig protects /metrics and the admin API. Either or both of basic
+// auth and a bearer token may be set.
+type AuthConfig struct {
+	Username    string `yaml:"username"`
+	Password    string `yaml:"password"`
+	BearerToken string `yaml:"bearer_token"`
+}
+
+// Enabled reports whether any credentials are configured.
+func (a AuthConfig) Enabled() bool {
+	return a.Username != "" || a.BearerToken != ""
+}
+
+// Duration is a time.Duration written as a Go duration string ("500ms") in
+// YAML and JSON.
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d *Duration) UnmarshalYAML(unmarshal func(any) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	return d.parse(s)
+}
+
+func (d Duration) MarshalYAML() (any, error) {
+	return d.String(), nil
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	return d.parse(s)
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) parse(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// LoadConfig reads, defaults and validates a config file.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(data)
+}
+
+// ParseConfig parses, defaults and validates config file contents.
+func ParseConfig(data []byte) (*Config, error) {
+	cfg := &Config{}
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, fmt.Errorf("parse config: %w", err)
+	}
+
+	cfg.applyDefaults()
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) applyDefaults() {
+	if len(c.Web.Listen) == 0 {
+		c.Web.Listen = []string{DefaultListenAddress}
+	}
+	if c.StateFile == "" {
+		c.StateFile = DefaultStateFile
+	}
+	// without an explicit list the first target defines the label keys
+	if c.TargetLabels == nil && len(c.Targets) > 0 {
+		c.TargetLabels = sortedKeys(c.Targets[0].Labels)
+	}
+	// the labels of expanded and discovered endpoints are exported empty by
+	// all other targets
+	for _, t := range c.Targets {
+		for _, name := range t.fanOutLabels() {
+			if !slices.Contains(c.TargetLabels, name) {
+				c.TargetLabels = append(c.TargetLabels, name)
+			}
+		}
+	}
+	if c.discoversInstances() && !slices.Contains(c.TargetLabels, InstanceLabel) {
+		c.TargetLabels = append(c.TargetLabels, InstanceLabel)
+	}
+	if c.Cardinality.Overflow == "" {
+		c.Cardinality.Overflow = OverflowRefuse
+	}
+	for i := range c.Targets {
+		c.Targets[i].applyDefaults(c.TargetNames)
+	}
+	for i := range c.FileSDConfigs {
+		c.FileSDConfigs[i].applyDefaults()
+	}
+	for i := range c.DNSSDConfigs {
+		c.DNSSDConfigs[i].applyDefaults()
+	}
+	for i := range c.KubeSDConfigs {
+		c.KubeSDConfigs[i].applyDefaults()
+	}
+	for i := range c.ConsulSDConfigs {
+		c.ConsulSDConfigs[i].applyDefaults()
+	}
+}
+
+// discoversInstances reports whether a discovery source creates one target
+// per endpoint, labelled with its instance.
+func (c *Config) discoversInstances() bool {
+	return len(c.DNSSDConfigs) > 0 || len(c.ConsulSDConfigs) > 0 ||
+		slices.ContainsFunc(c.KubeSDConfigs, func(sd KubernetesSDConfig) bool { return sd.Role == KubeRoleEndpoints })
+}
+
+func (c *Config) validate() error {
+	var errs []error
+
+	for i, addr := range c.Web.Listen {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			errs = append(errs, fmt.Errorf("web.listen[%d]: %w", i, err))
+		}
+	}
+	if tls := c.Web.TLS; tls != nil && (tls.CertFile == "" || tls.KeyFile == "") {
+		errs = append(errs, errors.New("web.tls: cert_file and key_file are required"))
+	}
+	if a := c.Web.Auth; (a.Username == "") != (a.Password == "") {
+		errs = append(errs, errors.New("web.auth: username and password must be set together"))
+	}
+	if err := c.Cardinality.validate(); err != nil {
+		errs = append(errs, fmt.Errorf("cardinality: %w", err))
+	}
+
+	for i, sd := range c.FileSDConfigs {
+		if err := sd.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("file_sd_configs[%d]: %w", i, err))
+		}
+	}
+	for i, sd := range c.DNSSDConfigs {
+		if err := sd.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("dns_sd_configs[%d]: %w", i, err))
+		}
+	}
+	for i, sd := range c.KubeSDConfigs {
+		if err := sd.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("kubernetes_sd_configs[%d]: %w", i, err))
+		}
+	}
+	for i, sd := range c.ConsulSDConfigs {
+		if err := sd.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("consul_sd_configs[%d]: %w", i, err))
+		}
+	}
+
+	names := make(map[string]bool)
+	for i, name := range c.TargetLabels {
+		if err := validateLabelName(name); err != nil {
+			errs = append(errs, fmt.Errorf("target_labels[%d]: %w", i, err))
+		}
+		if names[name] {
+			errs = append(errs, fmt.Errorf("target_labels[%d]: duplicate label %q", i, name))
+		}
+		names[name] = true
+	}
+
+	seen := make(map[string]bool)
+	for i, t := range c.Targets {
+		if err := t.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("targets[%d]: %w", i, err))
+			continue
+		}
+		if err := t.validateLabelKeys(c.TargetLabels); err != nil {
+			errs = append(errs, fmt.Errorf("targets[%d]: %w", i, err))
+		}
+		if seen[t.Name] {
+			errs = append(errs, fmt.Errorf("targets[%d]: duplicate target name %q", i, t.Name))
+		}
+		seen[t.Name] = true
+	}
+	return errors.Join(errs...)
+}
+
+func (t *Target) applyDefaults(naming NamingConfig) {
+	if t.Name == "" {
+		t.Name = naming.name(t.URL)
+	}
+	if t.Interval == 0 && t.Throughput != nil {
+		t.Interval = Duration(DefaultThroughputInterval)
+	}
+	if t.Interval == 0 && t.kind() == KindPath {
+		t.Interval = Duration(DefaultPathInterval)
+	}
+	if t.Interval == 0 && t.kind() == KindMTU {
+		t.Interval = Duration(DefaultMTUInterval)
+	}
+	if t.Interval == 0 {
+		t.Interval = Duration(DefaultInterval)
+	}
+}
+
+// validate checks a single target. The config loader and the admin API share
+// these rules.
+func (t *Target) validate() error {
+	if t.Name == "" {
+		return errors.New("name is required")
+	}
+	if len(t.Name) > MaxNameLength {
+		return fmt.Errorf("name is longer than %d characters", MaxNameLength)
+	}
+	if t.URL == "" {
+		return errors.New("url is required")
+	}
+	u, err := url.Parse(t.URL)
+	if err != nil {
+		return fmt.Errorf("invalid url %q: %w", t.URL, err)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid url %q: missing host", t.URL)
+	}
+	kind := t.kind()
+	if t.GRPC != nil && kind != KindGRPC {
+		return errors.New("grpc options require a grpc or grpcs url")
+	}
+	if t.WebSocket != nil && kind != KindWebSocket {
+		return errors.New("websocket options require a ws or wss url")
+	}
+	if t.UDP != nil && kind != KindUDP {
+		return errors.New("udp options require a udp url")
+	}
+	if t.Mail != nil && kind != KindSMTP && kind != KindIMAP && kind != KindPOP3 {
+		return errors.New("mail options require an smtp, imap or pop3 url")
+	}
+	if t.Database != nil && kind != KindPostgres && kind != KindMySQL && kind != KindRedis {
+		return errors.New("database options require a postgres, mysql or redis url")
+	}
+	if t.Path != nil && kind != KindPath {
+		return errors.New("path options require a traceroute url")
+	}
+	if t.MTU != nil && kind != KindMTU {
+		return errors.New("mtu options require an mtu url")
+	}
+	if t.HTTP != nil && kind != KindHTTP {
+		return errors.New("http options require an http or https url")
+	}
+	if t.Script != nil && kind != KindHTTP {
+		return errors.New("script options require an http or https url")
+	}
+	if t.Throughput != nil && kind != KindHTTP {
+		return errors.New("throughput options require an http or https url")
+	}
+	switch kind {
+	case KindHTTP:
+		if err := t.HTTP.validate(t); err != nil {
+			return err
+		}
+		if err := t.Script.validate(); err != nil {
+			return err
+		}
+		if err := t.Throughput.validate(t); err != nil {
+			return err
+		}
+	case KindGRPC:
+		if err := t.GRPC.validate(t); err != nil {
+			return fmt.Errorf("invalid url %q: %w", t.URL, err)
+		}
+	case KindWebSocket:
+		if err := t.WebSocket.validate(); err != nil {
+			return err
+		}
+	case KindUDP:
+		if err := t.UDP.validate(t); err != nil {
+			return err
+		}
+	case KindSMTP, KindIMAP, KindPOP3:
+		if err := t.Mail.validate(t); err != nil {
+			return err
+		}
+	case KindPostgres, KindMySQL, KindRedis:
+		if err := t.Database.validate(t); err != nil {
+			return err
+		}
+	case KindPath:
+		if err := t.Path.validate(t); err != nil {
+			return err
+		}
+	case KindMTU:
+		if err := t.MTU.validate(t); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid url %q: unsupported scheme %q", t.URL, u.Scheme)
+	}
+
+	if t.Address != "" {
+		if _, _, err := net.SplitHostPort(t.Address); err != nil {
+			return fmt.Errorf("invalid address %q: %w", t.Address, err)
+		}
+		if t.fansOut() {
+			return errors.New("address cannot be combined with fan_out or ip_version both")
+		}
+	}
+	switch t.IPVersion {
+	case "", IPVersion4, IPVersion6, IPVersionBoth:
+	default:
+		return fmt.Errorf("invalid ip_version %q, want ipv4, ipv6 or both", t.IPVersion)
+	}
+	for _, name := range t.fanOutLabels() {
+		if _, ok := t.Labels[name]; ok {
+			return fmt.Errorf("label %q is set on each expanded target", name)
+		}
+	}
+
+	if time.Duration(t.Interval) < MinInterval {
+		return fmt.Errorf("interval %s is below the minimum of %s", t.Interval, MinInterval)
+	}
+
+	for k := range t.Labels {
+		if err := validateLabelName(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateLabelKeys checks that the target sets exactly the given custom
+// labels, so that every target exports the same label dimensions. The labels
+// of expanded targets count as set on fan-out targets and may be left out by
+// all others, which export them empty.
+func (t *Target) validateLabelKeys(names []string) error {
+	got := slices.Sorted(slices.Values(append(sortedKeys(t.Labels), t.fanOutLabels()...)))
+	want := slices.Sorted(slices.Values(slices.DeleteFunc(slices.Clone(names), func(name string) bool {
+		return (name == InstanceLabel || name == IPVersionLabel) && !slices.Contains(got, name)
+	})))
+
+	if !slices.Equal(got, want) {
+		return fmt.Errorf("labels [%s] do not match target_labels [%s]",
+			strings.Join(got, ", "), strings.Join(want, ", "))
+	}
+	return nil
+}
+
+var labelNameRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// reservedLabels are label names netpulse sets itself.
+var reservedLabels = map[string]bool{
+	"target":       true,
+	"status":       true,
+	"error_reason": true,
+	"family":       true,
+	"fallback":     true,
+	"step":         true,
+	"hop":          true,
+	"address":      true,
+	"protocol":     true,
+	"connection":   true,
+	"encoding":     true,
+}
+
+func validateLabelName(name string) error {
+	if !labelNameRE.MatchString(name) {
+		return fmt.Errorf("invalid label name %q", name)
+	}
+	if strings.HasPrefix(name, "__") {
+		return fmt.Errorf("label name %q is reserved for internal use", name)
+	}
+	if reservedLabels[name] {
+		return fmt.Errorf("label name %q is set by netpulse", name)
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// readSecret returns the secret held in file or in the environment variable
+// env, or "" when neither is set.
+func readSecret(file, env string) (string, error) {
+	switch {
+	case file != "":
+		return readSecretFile(file)
+	case env != "":
+		v, ok := os.LookupEnv(env)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", env)
+		}
+		return v, nil
+	}
+	return "", nil
+}
+
+// readSecretFile reads a password or token from a file, dropping the trailing
+// newline most editors add.
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
@@ -0,0 +1,286 @@
+// Copyright (c) 2025 Dakhil Y.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultConsulServer = "http://localhost:8500"
+	DefaultConsulWait   = 5 * time.Minute
+
+	// ConsulRetryInterval is the pause after a failed catalog request.
+	ConsulRetryInterval = 5 * time.Second
+
+	// Service meta keys that override the scheme and path of an instance.
+	ConsulSchemeMeta = "netpulse_scheme"
+	ConsulPathMeta   = "netpulse_path"
+
+	// ConsulMetaLabelPrefix is prepended to service meta keys.
+	ConsulMetaLabelPrefix = "meta_"
+)
+
+// ConsulSDConfig discovers one target per instance of the listed Consul
+// services. Only instances carrying every tag in Tags are probed.
+type ConsulSDConfig struct {
+	Server      string   `yaml:"server"`
+	Token       string   `yaml:"token"`
+	Datacenter  string   `yaml:"datacenter"`
+	Services    []string `yaml:"services"`
+	Tags        []string `yaml:"tags"`
+	PassingOnly bool     `yaml:"passing_only"`
+	Wait        Duration `yaml:"wait"`
+
+	DiscoveryTarget `yaml:",inline"`
+}
+
+func (c *ConsulSDConfig) applyDefaults() {
+	if c.Server == "" {
+		c.Server = envOr("CONSUL_HTTP_ADDR", DefaultConsulServer)
+	}
+	if !strings.Contains(c.Server, "://") {
+		c.Server = "http://" + c.Server
+	}
+	if c.Token == "" {
+		c.Token = os.Getenv("CONSUL_HTTP_TOKEN")
+	}
+	if c.Wait == 0 {
+		c.Wait = Duration(DefaultConsulWait)
+	}
+	c.DiscoveryTarget.applyDefaults()
+}
+
+func (c ConsulSDConfig) validate() error {
+	u, err := url.Parse(c.Server)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid server %q", c.Server)
+	}
+	if len(c.Services) == 0 {
+		return errors.New("services is required")
+	}
+	if c.Wait <= 0 {
+		return errors.New("wait must be positive")
+	}
+	return c.DiscoveryTarget.validate()
+}
+
+// consulEntry is the subset of a /v1/health/service entry netpulse uses.
+type consulEntry struct {
+	Node struct {
+		Node       string `json:"Node"`
+		Address    string `json:"Address"`
+		Datacenter string `json:"Datacenter"`
+	} `json:"Node"`
+	Service struct {
+		ID      string            `json:"ID"`
+		Service string            `json:"Service"`
+		Tags    []string          `json:"Tags"`
+		Address string            `json:"Address"`
+		Port    int               `json:"Port"`
+		Meta    map[string]string `json:"Meta"`
+	} `json:"Service"`
+}
+
+// consulSD runs one blocking query per service against the health API. A
+// service whose query fails keeps its previous targets until it succeeds.
+type consulSD struct {
+	name   string
+	cfg    ConsulSDConfig
+	naming NamingConfig
+	client *http.Client
+}
+
+type consulUpdate struct {
+	service string
+	targets []Target
+}
+
+func newConsulSD(name string, cfg ConsulSDConfig, naming NamingConfig) *consulSD {
+	return &consulSD{
+		name:   name,
+		cfg:    cfg,
+		naming: naming,
+		// blocking queries are held open for up to wait plus a jitter of
+		// wait/16 by the server
+		client: &http.Client{Timeout: time.Duration(cfg.Wait)*17/16 + 10*time.Second},
+	}
+}
+
+func (d *consulSD) source() string {
+	return d.name
+}
+
+func (d *consulSD) run(ctx context.Context, update func([]Target)) {
+	updates := make(chan consulUpdate)
+	for _, svc := range d.cfg.Services {
+		go d.watch(ctx, svc, updates)
+	}
+
+	last := make(map[string][]Target)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case u := <-updates:
+			last[u.service] = u.targets
+		}
+
+		var all []Target
+		for _, svc := range d.cfg.Services {
+			all = append(all, last[svc]...)
+		}
+		update(all)
+	}
+}
+
+// watch long-polls one service and sends its targets whenever the catalog
+// index moves.
+func (d *consulSD) watch(ctx context.Context, service string, updates chan<- consulUpdate) {
+	var index uint64
+	for {
+		entries, next, err := d.fetch(ctx, service, index)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			fmt.Printf("Error querying Consul service %s in %s: %v\n", service, d.name, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(ConsulRetryInterval):
+			}
+			continue
+		}
+
+		// an unchanged index means the wait expired without changes
+		if index == 0 || next != index {
+			select {
+			case updates <- consulUpdate{service, d.targets(entries)}:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		switch {
+		case next == 0:
+			// no index to block on, fall back to polling
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(ConsulRetryInterval):
+			}
+		case next < index:
+			// the index went backwards, so the server state was reset
+			index = 0
+		default:
+			index = next
+		}
+	}
+}
+
+func (d *consulSD) fetch(ctx context.Context, service string, index uint64) ([]consulEntry, uint64, error) {
+	q := url.Values{}
+	if d.cfg.Datacenter != "" {
+		q.Set("dc", d.cfg.Datacenter)
+	}
+	if d.cfg.PassingOnly {
+		q.Set("passing", "true")
+	}
+	if index > 0 {
+		q.Set("index", strconv.FormatUint(index, 10))
+		q.Set("wait", fmt.Sprintf("%ds", int(time.Duration(d.cfg.Wait).Seconds())))
+	}
+	u := strings.TrimSuffix(d.cfg.Server, "/") + "/v1/health/service/" + url.PathEscape(service) + "?" + q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if d.cfg.Token != "" {
+		req.Header.Set("X-Consul-Token", d.cfg.Token)
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var entries []consulEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, 0, fmt.Errorf("decode response: %w", err)
+	}
+	next, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	return entries, next, nil
+}
+
+func (d *consulSD) targets(entries []consulEntry) []Target {
+	var out []Target
+	for _, e := range entries {
+		if !hasAllTags(e.Service.Tags, d.cfg.Tags) {
+			continue
+		}
+
+		host := e.Service.Address
+		if host == "" {
+			host = e.Node.Address
+		}
+		instance := net.JoinHostPort(host, strconv.Itoa(e.Service.Port))
+
+		labels := map[string]string{
+			SchemeLabel:   e.Service.Meta[ConsulSchemeMeta],
+			PathLabel:     e.Service.Meta[ConsulPathMeta],
+			"service":     e.Service.Service,
+			"node":        e.Node.Node,
+			"datacenter":  e.Node.Datacenter,
+			InstanceLabel: instance,
+		}
+		for k, v := range e.Service.Meta {
+			if k == ConsulSchemeMeta || k == ConsulPathMeta {
+				continue
+			}
+			labels[consulMetaLabel(k)] = v
+		}
+
+		t := d.cfg.newTarget(instance, labels, d.naming)
+		if err := t.validate(); err != nil {
+			fmt.Printf("Skipping Consul instance %s from %s: %v\n", e.Service.ID, d.name, err)
+			continue
+		}
+		out = append(out, t)
+	}
+
+	slices.SortFunc(out, func(a, b Target) int { return strings.Compare(a.Name, b.Name) })
+	return out
+}
+
+func hasAllTags(have, want []string) bool {
+	for _, tag := range want {
+		if !slices.Contains(have, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+var invalidLabelCharRE = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// consulMetaLabel turns a service meta key into a valid label name.
+func consulMetaLabel(key string) string {
+	return ConsulMetaLabelPrefix + invalidLabelCharRE.ReplaceAllString(key, "_")
+}
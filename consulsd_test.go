@@ -0,0 +1,299 @@
+// Copyright (c) 2025 Dakhil Y.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// consulResponse is one scripted answer of fakeConsul. after delays it until
+// the channel is closed; a zero index sends no X-Consul-Index header.
+type consulResponse struct {
+	status  int
+	index   uint64
+	entries []consulEntry
+	after   chan struct{}
+}
+
+type consulRequest struct {
+	service string
+	query   url.Values
+	token   string
+}
+
+// fakeConsul answers health queries with the responses scripted per
+// service, then holds further queries open like a blocking query that never
+// sees a change.
+type fakeConsul struct {
+	mu        sync.Mutex
+	responses map[string][]consulResponse
+	requests  chan consulRequest
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	service, ok := strings.CutPrefix(r.URL.Path, "/v1/health/service/")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	f.requests <- consulRequest{service: service, query: r.URL.Query(), token: r.Header.Get("X-Consul-Token")}
+
+	f.mu.Lock()
+	rs := f.responses[service]
+	if len(rs) == 0 {
+		f.mu.Unlock()
+		<-r.Context().Done()
+		return
+	}
+	resp := rs[0]
+	f.responses[service] = rs[1:]
+	f.mu.Unlock()
+
+	if resp.after != nil {
+		<-resp.after
+	}
+	if resp.status != 0 {
+		http.Error(w, "scripted failure", resp.status)
+		return
+	}
+	if resp.index != 0 {
+		w.Header().Set("X-Consul-Index", strconv.FormatUint(resp.index, 10))
+	}
+	json.NewEncoder(w).Encode(resp.entries)
+}
+
+// runConsulSD runs a consulSD against a fakeConsul with the given responses
+// and returns the channels of its requests and target updates.
+func runConsulSD(t *testing.T, cfg ConsulSDConfig, responses map[string][]consulResponse) (<-chan consulRequest, <-chan []Target) {
+	t.Helper()
+	f := &fakeConsul{responses: responses, requests: make(chan consulRequest, 64)}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+
+	cfg.Server = srv.URL
+	cfg.Wait = Duration(time.Second)
+	cfg.applyDefaults()
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	d := newConsulSD("consul_sd_configs[0]", cfg, NamingConfig{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	updates := make(chan []Target, 64)
+	go d.run(ctx, func(ts []Target) { updates <- ts })
+	return f.requests, updates
+}
+
+func consulInstance(service, address string, port int, tags []string, meta map[string]string) consulEntry {
+	var e consulEntry
+	e.Node.Node = "node-" + address
+	e.Node.Address = address
+	e.Node.Datacenter = "dc1"
+	e.Service.ID = service + "-" + address
+	e.Service.Service = service
+	e.Service.Address = address
+	e.Service.Port = port
+	e.Service.Tags = tags
+	e.Service.Meta = meta
+	return e
+}
+
+func nextRequest(t *testing.T, requests <-chan consulRequest) consulRequest {
+	t.Helper()
+	select {
+	case r := <-requests:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("no request")
+		return consulRequest{}
+	}
+}
+
+func nextUpdate(t *testing.T, updates <-chan []Target) []Target {
+	t.Helper()
+	select {
+	case ts := <-updates:
+		return ts
+	case <-time.After(5 * time.Second):
+		t.Fatal("no update")
+		return nil
+	}
+}
+
+func TestConsulSDBlockingQueries(t *testing.T) {
+	a := consulInstance("web", "10.0.0.1", 80, nil, nil)
+	b := consulInstance("web", "10.0.0.2", 80, nil, nil)
+	c := consulInstance("web", "10.0.0.3", 80, nil, nil)
+	requests, updates := runConsulSD(t, ConsulSDConfig{Services: []string{"web"}}, map[string][]consulResponse{
+		"web": {
+			{index: 10, entries: []consulEntry{a}},
+			// the wait expired without a change
+			{index: 10, entries: []consulEntry{a}},
+			{index: 12, entries: []consulEntry{a, b}},
+			// the server state was reset
+			{index: 5, entries: []consulEntry{b}},
+			{index: 6, entries: []consulEntry{c}},
+		},
+	})
+
+	wantIndex := []string{"", "10", "10", "12", "", "6"}
+	for i, want := range wantIndex {
+		r := nextRequest(t, requests)
+		if got := r.query.Get("index"); got != want {
+			t.Errorf("request %d: index = %q, want %q", i, got, want)
+		}
+		wantWait := ""
+		if want != "" {
+			wantWait = "1s"
+		}
+		if got := r.query.Get("wait"); got != wantWait {
+			t.Errorf("request %d: wait = %q, want %q", i, got, wantWait)
+		}
+	}
+
+	wantUpdates := [][]string{
+		{"http://10.0.0.1:80/"},
+		{"http://10.0.0.1:80/", "http://10.0.0.2:80/"},
+		{"http://10.0.0.2:80/"},
+		{"http://10.0.0.3:80/"},
+	}
+	for i, want := range wantUpdates {
+		if got := targetURLs(nextUpdate(t, updates)); !slices.Equal(got, want) {
+			t.Errorf("update %d: urls = %v, want %v", i, got, want)
+		}
+	}
+	select {
+	case ts := <-updates:
+		t.Errorf("unexpected update %v", targetURLs(ts))
+	default:
+	}
+}
+
+func TestConsulSDFiltersAndLabels(t *testing.T) {
+	tests := []struct {
+		name        string
+		passingOnly bool
+	}{
+		{name: "all", passingOnly: false},
+		{name: "passing only", passingOnly: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			noAddress := consulInstance("web", "", 8081, []string{"prod"}, nil)
+			noAddress.Node.Address = "192.168.0.2"
+			noAddress.Node.Node = "node-2"
+
+			requests, updates := runConsulSD(t, ConsulSDConfig{
+				Services:    []string{"web"},
+				Tags:        []string{"prod"},
+				PassingOnly: tt.passingOnly,
+				Datacenter:  "dc1",
+				Token:       "secret",
+			}, map[string][]consulResponse{
+				"web": {{index: 1, entries: []consulEntry{
+					consulInstance("web", "10.0.0.1", 8443, []string{"prod", "v2"}, map[string]string{
+						ConsulSchemeMeta: "https",
+						ConsulPathMeta:   "/health",
+						"version":        "1.2",
+						"team-name":      "core",
+					}),
+					noAddress,
+					consulInstance("web", "10.0.0.3", 8080, []string{"staging"}, nil),
+				}}},
+			})
+
+			r := nextRequest(t, requests)
+			if r.service != "web" || r.token != "secret" || r.query.Get("dc") != "dc1" {
+				t.Errorf("request = %+v, want service web, token secret and dc dc1", r)
+			}
+			wantPassing := ""
+			if tt.passingOnly {
+				wantPassing = "true"
+			}
+			if got := r.query.Get("passing"); got != wantPassing {
+				t.Errorf("passing = %q, want %q", got, wantPassing)
+			}
+
+			ts := nextUpdate(t, updates)
+			want := []string{"http://192.168.0.2:8081/", "https://10.0.0.1:8443/health"}
+			if got := targetURLs(ts); !slices.Equal(got, want) {
+				t.Fatalf("urls = %v, want %v", got, want)
+			}
+
+			wantLabels := map[string]string{
+				"service":        "web",
+				"node":           "node-10.0.0.1",
+				"datacenter":     "dc1",
+				InstanceLabel:    "10.0.0.1:8443",
+				"meta_version":   "1.2",
+				"meta_team_name": "core",
+			}
+			if got := ts[1].Labels; !maps.Equal(got, wantLabels) {
+				t.Errorf("labels = %v, want %v", got, wantLabels)
+			}
+			if got := ts[0].Labels["node"]; got != "node-2" {
+				t.Errorf("node = %q, want node-2", got)
+			}
+		})
+	}
+}
+
+func TestConsulSDFailedQueryKeepsTargets(t *testing.T) {
+	dbFailed := make(chan struct{})
+	requests, updates := runConsulSD(t, ConsulSDConfig{Services: []string{"db", "web"}}, map[string][]consulResponse{
+		"db": {
+			{index: 3, entries: []consulEntry{consulInstance("db", "10.0.1.1", 5432, nil, nil)}},
+			{status: http.StatusInternalServerError},
+		},
+		"web": {
+			{index: 7, entries: []consulEntry{consulInstance("web", "10.0.0.1", 80, nil, nil)}},
+			{index: 8, entries: []consulEntry{consulInstance("web", "10.0.0.2", 80, nil, nil)}, after: dbFailed},
+		},
+	})
+
+	// web only changes once the second db query has failed
+	dbRequests := 0
+	for dbRequests < 2 {
+		if nextRequest(t, requests).service == "db" {
+			dbRequests++
+		}
+	}
+	close(dbFailed)
+
+	want := []string{"http://10.0.0.2:80/", "http://10.0.1.1:5432/"}
+	for {
+		got := targetURLs(nextUpdate(t, updates))
+		if !slices.Contains(got, "http://10.0.0.2:80/") {
+			continue
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, want) {
+			t.Errorf("urls = %v, want %v", got, want)
+		}
+		return
+	}
+}
+
+func TestConsulMetaLabel(t *testing.T) {
+	tests := map[string]string{
+		"version":      "meta_version",
+		"team-name":    "meta_team_name",
+		"k8s.io/owner": "meta_k8s_io_owner",
+	}
+	for key, want := range tests {
+		if got := consulMetaLabel(key); got != want {
+			t.Errorf("consulMetaLabel(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
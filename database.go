@@ -0,0 +1,439 @@
+// Copyright (c) 2025 Dakhil Y.
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// StepQuery is the trivial query a database probe runs after the handshake.
+const StepQuery = "query"
+
+// DatabaseOptions configures a postgres, mysql or redis target. The password
+// is read from PasswordFile or the PasswordEnv environment variable on every
+// probe, so rotated secrets are picked up; it may not appear in the URL.
+// Username overrides the user of the URL.
+type DatabaseOptions struct {
+	Username     string `yaml:"username" json:"username,omitempty"`
+	PasswordFile string `yaml:"password_file" json:"password_file,omitempty"`
+	PasswordEnv  string `yaml:"password_env" json:"password_env,omitempty"`
+}
+
+func (o *DatabaseOptions) validate(t *Target) error {
+	u, err := url.Parse(t.URL)
+	if err != nil {
+		return err
+	}
+	if _, ok := u.User.Password(); ok {
+		return fmt.Errorf("invalid url %q: set the password with database.password_file or database.password_env",
+			u.Redacted())
+	}
+
+	switch t.kind() {
+	case KindPostgres:
+		if _, err := pgx.ParseConfig(t.URL); err != nil {
+			return fmt.Errorf("invalid url %q: %w", t.URL, err)
+		}
+	case KindMySQL:
+		if _, err := mysqlConfig(u); err != nil {
+			return fmt.Errorf("invalid url %q: %w", t.URL, err)
+		}
+	case KindRedis:
+		if _, err := redisDB(u); err != nil {
+			return fmt.Errorf("invalid url %q: %w", t.URL, err)
+		}
+	}
+
+	if o != nil && o.PasswordFile != "" && o.PasswordEnv != "" {
+		return errors.New("database.password_file and database.password_env are mutually exclusive")
+	}
+	return nil
+}
+
+// password returns the configured password, or "" when none is.
+func (o DatabaseOptions) password() (string, error) {
+	return readSecret(o.PasswordFile, o.PasswordEnv)
+}
+
+// databaseConn is an open, authenticated database connection.
+type databaseConn interface {
+	query(ctx context.Context) error
+	close(ctx context.Context)
+}
+
+// databaseConnector opens a connection with the given credentials over dial.
+// An empty user keeps the one of the URL.
+type databaseConnector func(ctx context.Context, dial dialFunc, user, password string) (databaseConn, error)
+
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+type databaseProber struct {
+	target  Target
+	opts    DatabaseOptions
+	connect databaseConnector
+	// classify returns the failure reason of an error reported by the
+	// server, or "" for any other error.
+	classify func(err error) string
+	dial     dialFunc
+}
+
+func newDatabaseProber(t Target) (probeFunc, func()) {
+	u, _ := url.Parse(t.URL)
+	p := &databaseProber{target: t, dial: dialContext(t)}
+	if t.Database != nil {
+		p.opts = *t.Database
+	}
+
+	switch t.kind() {
+	case KindPostgres:
+		cfg, _ := pgx.ParseConfig(t.URL)
+		// one round trip for the query instead of prepare and execute
+		cfg.DefaultQueryExecMode = pgx.QueryExecModeSimpleProtocol
+		p.connect = postgresConnector(cfg)
+		p.classify = classifyPostgresError
+	case KindMySQL:
+		cfg, _ := mysqlConfig(u)
+		p.connect = mysqlConnector(cfg)
+		p.classify = classifyMySQLError
+	case KindRedis:
+		p.connect = redisConnector(u)
+		p.classify = classifyRedisError
+	}
+	return p.probe, func() {}
+}
+
+// probe opens a new connection each time and reports the handshake,
+// including authentication, and the query as separate steps.
+func (p *databaseProber) probe(ctx context.Context) (Result, error) {
+	res := Result{
+		Target:      p.target.Name,
+		Time:        time.Now(),
+		Status:      "success",
+		ErrorReason: FailureNone,
+	}
+	ctx, cancel := context.WithTimeout(ctx, httpClient.Timeout)
+	defer cancel()
+
+	fail := func(reason string, err error) (Result, error) {
+		res.Latency = time.Since(res.Time).Seconds()
+		if reason != "" {
+			res.Status = "database_error"
+			res.ErrorReason = reason
+			// unreadable credentials are logged, server replies are not
+			if reason == FailureCredentials {
+				return res, err
+			}
+			return res, nil
+		}
+		res.Status = "transport_error"
+		res.ErrorReason = classifyTransportError(err)
+		return res, err
+	}
+
+	password, err := p.opts.password()
+	if err != nil {
+		return fail(FailureCredentials, err)
+	}
+
+	// remember the address of the connection the driver opens
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := p.dial(ctx, network, addr)
+		if err == nil {
+			if addr, err := netip.ParseAddrPort(conn.RemoteAddr().String()); err == nil {
+				res.IPVersion = addrFamily(addr.Addr())
+			}
+		}
+		return conn, err
+	}
+
+	conn, err := p.connect(ctx, dial, p.opts.Username, password)
+	if err != nil {
+		return fail(p.classify(err), err)
+	}
+	defer conn.close(ctx)
+	handshake := time.Now()
+	res.Steps = append(res.Steps, Step{Name: StepHandshake, Latency: handshake.Sub(res.Time).Seconds()})
+
+	if err := conn.query(ctx); err != nil {
+		reason := p.classify(err)
+		if reason == FailureProtocolError {
+			reason = FailureQueryFailed
+		}
+		return fail(reason, err)
+	}
+	res.Steps = append(res.Steps, Step{Name: StepQuery, Latency: time.Since(handshake).Seconds()})
+	res.Latency = time.Since(res.Time).Seconds()
+	return res, nil
+}
+
+// Postgres
+
+type postgresConn struct {
+	*pgx.Conn
+}
+
+func (c postgresConn) query(ctx context.Context) error {
+	var n int
+	return c.QueryRow(ctx, "SELECT 1").Scan(&n)
+}
+
+func (c postgresConn) close(ctx context.Context) {
+	c.Close(ctx)
+}
+
+func postgresConnector(cfg *pgx.ConnConfig) databaseConnector {
+	return func(ctx context.Context, dial dialFunc, user, password string) (databaseConn, error) {
+		cfg := cfg.Copy()
+		cfg.DialFunc = pgconn.DialFunc(dial)
+		if user != "" {
+			cfg.User = user
+		}
+		if password != "" {
+			cfg.Password = password
+		}
+		conn, err := pgx.ConnectConfig(ctx, cfg)
+		if err != nil {
+			return nil, err
+		}
+		return postgresConn{conn}, nil
+	}
+}
+
+func classifyPostgresError(err error) string {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return ""
+	}
+	// class 28 is invalid authorization specification
+	if strings.HasPrefix(pgErr.Code, "28") {
+		return FailureAuthFailed
+	}
+	return FailureProtocolError
+}
+
+// MySQL
+
+type mysqlConn struct {
+	driver.Conn
+}
+
+func (c mysqlConn) query(ctx context.Context) error {
+	rows, err := c.Conn.(driver.QueryerContext).QueryContext(ctx, "SELECT 1", nil)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	return rows.Next(make([]driver.Value, 1))
+}
+
+func (c mysqlConn) close(context.Context) {
+	c.Close()
+}
+
+// mysqlConfig converts a mysql://user@host:port/dbname?param=value URL into
+// a driver configuration. The parameters are those of the driver's DSN.
+func mysqlConfig(u *url.URL) (*mysql.Config, error) {
+	dsn := fmt.Sprintf("tcp(%s)/%s", hostPort(u), strings.TrimPrefix(u.Path, "/"))
+	if u.RawQuery != "" {
+		dsn += "?" + u.RawQuery
+	}
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	cfg.User = u.User.Username()
+	return cfg, nil
+}
+
+func mysqlConnector(cfg *mysql.Config) databaseConnector {
+	return func(ctx context.Context, dial dialFunc, user, password string) (databaseConn, error) {
+		cfg := cfg.Clone()
+		cfg.DialFunc = dial
+		if user != "" {
+			cfg.User = user
+		}
+		cfg.Passwd = password
+		connector, err := mysql.NewConnector(cfg)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := connector.Connect(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return mysqlConn{conn}, nil
+	}
+}
+
+func classifyMySQLError(err error) string {
+	var myErr *mysql.MySQLError
+	if !errors.As(err, &myErr) {
+		return ""
+	}
+	switch myErr.Number {
+	case 1044, 1045, 1698: // access denied to the database, for the user or with this plugin
+		return FailureAuthFailed
+	}
+	return FailureProtocolError
+}
+
+// Redis
+
+// redisError is an error reply.
+type redisError struct {
+	msg string
+	// auth is set for a reply to AUTH
+	auth bool
+}
+
+func (e *redisError) Error() string {
+	return "server replied: " + e.msg
+}
+
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// do sends a command and reads a simple string, integer or bulk string
+// reply.
+func (c *redisConn) do(args ...string) (string, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := io.WriteString(c.Conn, b.String()); err != nil {
+		return "", err
+	}
+
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return "", errors.New("empty reply")
+	}
+	switch kind, rest := line[0], line[1:]; kind {
+	case '+', ':':
+		return rest, nil
+	case '-':
+		return "", &redisError{msg: rest}
+	case '$':
+		n, err := strconv.Atoi(rest)
+		if err != nil || n < 0 {
+			return "", fmt.Errorf("invalid reply %q", line)
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return "", err
+		}
+		return string(buf[:n]), nil
+	}
+	return "", fmt.Errorf("unexpected reply %q", line)
+}
+
+func (c *redisConn) query(context.Context) error {
+	reply, err := c.do("PING")
+	if err != nil {
+		return err
+	}
+	if reply != "PONG" {
+		return &redisError{msg: fmt.Sprintf("unexpected PING reply %q", reply)}
+	}
+	return nil
+}
+
+func (c *redisConn) close(context.Context) {
+	c.do("QUIT")
+	c.Close()
+}
+
+// redisDB returns the database number of a redis://host:port/N URL.
+func redisDB(u *url.URL) (string, error) {
+	db := strings.TrimPrefix(u.Path, "/")
+	if db == "" {
+		return "", nil
+	}
+	if _, err := strconv.ParseUint(db, 10, 32); err != nil {
+		return "", fmt.Errorf("invalid database number %q", db)
+	}
+	return db, nil
+}
+
+func redisConnector(u *url.URL) databaseConnector {
+	addr := hostPort(u)
+	db, _ := redisDB(u)
+	tlsConfig := &tls.Config{ServerName: u.Hostname()}
+	useTLS := u.Scheme == "rediss"
+
+	return func(ctx context.Context, dial dialFunc, user, password string) (databaseConn, error) {
+		conn, err := dial(ctx, "tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		if deadline, ok := ctx.Deadline(); ok {
+			conn.SetDeadline(deadline)
+		}
+		if useTLS {
+			tlsConn := tls.Client(conn, tlsConfig)
+			if err := tlsConn.HandshakeContext(ctx); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			conn = tlsConn
+		}
+		c := &redisConn{Conn: conn, r: bufio.NewReader(conn)}
+
+		if user == "" {
+			user = u.User.Username()
+		}
+		if password != "" {
+			args := []string{"AUTH", password}
+			if user != "" {
+				args = []string{"AUTH", user, password}
+			}
+			if _, err := c.do(args...); err != nil {
+				if redisErr, ok := err.(*redisError); ok {
+					redisErr.auth = true
+				}
+				c.Close()
+				return nil, err
+			}
+		}
+		if db != "" {
+			if _, err := c.do("SELECT", db); err != nil {
+				c.Close()
+				return nil, err
+			}
+		}
+		return c, nil
+	}
+}
+
+func classifyRedisError(err error) string {
+	var redisErr *redisError
+	if !errors.As(err, &redisErr) {
+		return ""
+	}
+	if redisErr.auth || strings.HasPrefix(redisErr.msg, "NOAUTH") || strings.HasPrefix(redisErr.msg, "WRONGPASS") {
+		return FailureAuthFailed
+	}
+	return FailureProtocolError
+}
@@ -0,0 +1,160 @@
+// Copyright (c) 2025 Dakhil Y.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultRefreshInterval = 30 * time.Second
+
+	// Labels with this prefix are only used while building a target and are
+	// never exported.
+	MetaLabelPrefix = "__"
+	SchemeLabel     = "__scheme__"
+	PathLabel       = "__path__"
+)
+
+// discoverer produces the complete target list of one discovery source each
+// time it changes.
+type discoverer interface {
+	source() string
+	run(ctx context.Context, update func([]Target))
+}
+
+// targetGroup is a set of addresses sharing labels, as in Prometheus
+// service discovery.
+type targetGroup struct {
+	Targets []string          `json:"targets" yaml:"targets"`
+	Labels  map[string]string `json:"labels" yaml:"labels"`
+}
+
+// DiscoveryTarget holds the settings applied to every target of a discovery
+// source. Addresses without a scheme are probed as Scheme://address/Path.
+type DiscoveryTarget struct {
+	Scheme   string            `yaml:"scheme"`
+	Path     string            `yaml:"path"`
+	Interval Duration          `yaml:"interval"`
+	Labels   map[string]string `yaml:"labels"`
+}
+
+func (d *DiscoveryTarget) applyDefaults() {
+	if d.Scheme == "" {
+		d.Scheme = "http"
+	}
+	if d.Path == "" {
+		d.Path = "/"
+	}
+	if d.Interval == 0 {
+		d.Interval = Duration(DefaultInterval)
+	}
+}
+
+func (d DiscoveryTarget) validate() error {
+	if d.Scheme != "http" && d.Scheme != "https" {
+		return errors.New("scheme must be http or https")
+	}
+	if !strings.HasPrefix(d.Path, "/") {
+		return errors.New("path must start with /")
+	}
+	if time.Duration(d.Interval) < MinInterval {
+		return fmt.Errorf("interval %s is below the minimum of %s", d.Interval, MinInterval)
+	}
+	for k := range d.Labels {
+		if err := validateLabelName(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// targets turns target groups into probe targets. Invalid entries are
+// logged and skipped so one bad entry does not drop a whole source.
+func (d DiscoveryTarget) targets(source string, groups []targetGroup, naming NamingConfig) []Target {
+	var out []Target
+	for _, g := range groups {
+		for _, addr := range g.Targets {
+			t := d.newTarget(addr, g.Labels, naming)
+			if err := t.validate(); err != nil {
+				fmt.Printf("Skipping target %q from %s: %v\n", addr, source, err)
+				continue
+			}
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// newTarget builds an unvalidated target for addr. labels override the
+// source's labels; meta labels are applied and then dropped.
+func (d DiscoveryTarget) newTarget(addr string, labels map[string]string, naming NamingConfig) Target {
+	t := Target{
+		URL:      d.url(addr, labels),
+		Interval: d.Interval,
+		Labels:   make(map[string]string, len(d.Labels)+len(labels)),
+	}
+	maps.Copy(t.Labels, d.Labels)
+	for k, v := range labels {
+		if !strings.HasPrefix(k, MetaLabelPrefix) {
+			t.Labels[k] = v
+		}
+	}
+	t.applyDefaults(naming)
+	return t
+}
+
+func (d DiscoveryTarget) url(addr string, labels map[string]string) string {
+	if strings.Contains(addr, "://") {
+		return addr
+	}
+
+	scheme, path := d.Scheme, d.Path
+	if v := labels[SchemeLabel]; v != "" {
+		scheme = v
+	}
+	if v := labels[PathLabel]; v != "" {
+		path = v
+	}
+	p, q, _ := strings.Cut(path, "?")
+	u := url.URL{Scheme: scheme, Host: addr, Path: p, RawQuery: q}
+	return u.String()
+}
+
+// discoverers builds the discovery sources of a config. Each source is named
+// after its config section and index.
+func (c *Config) discoverers() ([]discoverer, error) {
+	var ds []discoverer
+	for i, sd := range c.FileSDConfigs {
+		ds = append(ds, newFileSD(fmt.Sprintf("file_sd/%d", i), sd, c.TargetNames))
+	}
+	for i, sd := range c.DNSSDConfigs {
+		ds = append(ds, newDNSSD(fmt.Sprintf("dns_sd/%d", i), sd, c.TargetNames))
+	}
+	for i, sd := range c.KubeSDConfigs {
+		client, err := newKubeClient(sd.Kubeconfig)
+		if err != nil {
+			return nil, fmt.Errorf("kubernetes_sd_configs[%d]: %w", i, err)
+		}
+		ds = append(ds, newKubeSD(fmt.Sprintf("kubernetes_sd/%d", i), sd, c.TargetNames, client))
+	}
+	for i, sd := range c.ConsulSDConfigs {
+		ds = append(ds, newConsulSD(fmt.Sprintf("consul_sd/%d", i), sd, c.TargetNames))
+	}
+	return ds, nil
+}
+
+// startDiscovery runs every discoverer and applies its updates to the
+// scheduler until ctx is done.
+func startDiscovery(ctx context.Context, ds []discoverer, s *scheduler) {
+	for _, d := range ds {
+		go d.run(ctx, func(ts []Target) {
+			s.sync(d.source(), ts)
+		})
+	}
+}
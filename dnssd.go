@@ -0,0 +1,194 @@
+// Copyright (c) 2025 Dakhil Y.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DNSTypeSRV  = "SRV"
+	DNSTypeA    = "A"
+	DNSTypeAAAA = "AAAA"
+
+	// InstanceLabel holds the resolved endpoint a discovered target probes.
+	InstanceLabel = "instance"
+
+	DNSLookupTimeout = 10 * time.Second
+)
+
+// DNSSDConfig discovers one target per endpoint behind DNS names. SRV
+// records yield one target per record target and port. A and AAAA records
+// yield one target per address, probed with the DNS name in the URL so that
+// Host and SNI stay intact while the connection is pinned to the address.
+type DNSSDConfig struct {
+	Names           []string `yaml:"names"`
+	Type            string   `yaml:"type"`
+	Port            int      `yaml:"port"`
+	RefreshInterval Duration `yaml:"refresh_interval"`
+	DiscoveryTarget `yaml:",inline"`
+}
+
+func (c *DNSSDConfig) applyDefaults() {
+	if c.Type == "" {
+		c.Type = DNSTypeSRV
+	}
+	c.Type = strings.ToUpper(c.Type)
+	if c.RefreshInterval == 0 {
+		c.RefreshInterval = Duration(DefaultRefreshInterval)
+	}
+	c.DiscoveryTarget.applyDefaults()
+}
+
+func (c DNSSDConfig) validate() error {
+	if len(c.Names) == 0 {
+		return errors.New("names is required")
+	}
+	switch c.Type {
+	case DNSTypeSRV:
+		if c.Port != 0 {
+			return errors.New("port is taken from the SRV records and must not be set")
+		}
+	case DNSTypeA, DNSTypeAAAA:
+		if c.Port <= 0 || c.Port > 65535 {
+			return fmt.Errorf("port is required for %s records", c.Type)
+		}
+	default:
+		return fmt.Errorf("unsupported type %q, want SRV, A or AAAA", c.Type)
+	}
+	if c.RefreshInterval <= 0 {
+		return errors.New("refresh_interval must be positive")
+	}
+	return c.DiscoveryTarget.validate()
+}
+
+// dnsSD periodically resolves the configured names. A name that fails to
+// resolve keeps its previous targets, so a resolver hiccup does not remove
+// every target behind it; a name that no longer exists has none.
+type dnsSD struct {
+	name     string
+	cfg      DNSSDConfig
+	naming   NamingConfig
+	resolver *net.Resolver
+
+	last map[string][]Target
+}
+
+func newDNSSD(name string, cfg DNSSDConfig, naming NamingConfig) *dnsSD {
+	return &dnsSD{
+		name:     name,
+		cfg:      cfg,
+		naming:   naming,
+		resolver: net.DefaultResolver,
+		last:     make(map[string][]Target),
+	}
+}
+
+func (d *dnsSD) source() string {
+	return d.name
+}
+
+func (d *dnsSD) run(ctx context.Context, update func([]Target)) {
+	ticker := time.NewTicker(time.Duration(d.cfg.RefreshInterval))
+	defer ticker.Stop()
+
+	for {
+		d.refresh(ctx)
+
+		var all []Target
+		for _, name := range d.cfg.Names {
+			all = append(all, d.last[name]...)
+		}
+		update(all)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *dnsSD) refresh(ctx context.Context) {
+	for _, name := range d.cfg.Names {
+		ctx, cancel := context.WithTimeout(ctx, DNSLookupTimeout)
+		ts, err := d.resolve(ctx, name)
+		cancel()
+
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			ts, err = nil, nil
+		}
+		if err != nil {
+			fmt.Printf("Error resolving %s %s in %s: %v\n", d.cfg.Type, name, d.name, err)
+			continue
+		}
+		d.last[name] = ts
+	}
+}
+
+func (d *dnsSD) resolve(ctx context.Context, name string) ([]Target, error) {
+	if d.cfg.Type == DNSTypeSRV {
+		return d.resolveSRV(ctx, name)
+	}
+	return d.resolveIP(ctx, name)
+}
+
+func (d *dnsSD) resolveSRV(ctx context.Context, name string) ([]Target, error) {
+	_, records, err := d.resolver.LookupSRV(ctx, "", "", name)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []Target
+	for _, r := range records {
+		host := strings.TrimSuffix(r.Target, ".")
+		instance := net.JoinHostPort(host, strconv.Itoa(int(r.Port)))
+
+		t := d.cfg.newTarget(instance, map[string]string{InstanceLabel: instance}, d.naming)
+		if err := t.validate(); err != nil {
+			fmt.Printf("Skipping SRV target %s from %s: %v\n", instance, d.name, err)
+			continue
+		}
+		out = append(out, t)
+	}
+	return out, nil
+}
+
+func (d *dnsSD) resolveIP(ctx context.Context, name string) ([]Target, error) {
+	network := "ip4"
+	if d.cfg.Type == DNSTypeAAAA {
+		network = "ip6"
+	}
+
+	addrs, err := d.resolver.LookupNetIP(ctx, network, name)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(addrs, func(a, b netip.Addr) int { return a.Compare(b) })
+
+	port := strconv.Itoa(d.cfg.Port)
+	host := net.JoinHostPort(name, port)
+
+	var out []Target
+	for _, addr := range slices.Compact(addrs) {
+		instance := net.JoinHostPort(addr.Unmap().String(), port)
+
+		t := d.cfg.newTarget(host, map[string]string{InstanceLabel: instance}, d.naming)
+		t.Address = instance
+		t.Name += "@" + instance
+		if err := t.validate(); err != nil {
+			fmt.Printf("Skipping %s target %s from %s: %v\n", d.cfg.Type, instance, d.name, err)
+			continue
+		}
+		out = append(out, t)
+	}
+	return out, nil
+}
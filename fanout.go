@@ -0,0 +1,184 @@
+// Copyright (c) 2025 Dakhil Y.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"slices"
+	"time"
+)
+
+const (
+	// FanOutRefreshInterval is how often a fan-out target resolves its host.
+	FanOutRefreshInterval = 30 * time.Second
+
+	// Address families a target can be restricted to. The default probes
+	// whichever family the dialer connects on first.
+	IPVersion4    = "ipv4"
+	IPVersion6    = "ipv6"
+	IPVersionBoth = "both"
+
+	// IPVersionLabel holds the address family of a dual-stack target.
+	IPVersionLabel = "ip_version"
+)
+
+// fansOut reports whether a target is probed as several pinned targets, one
+// per address or address family, instead of being probed itself.
+func (t *Target) fansOut() bool {
+	return t.FanOut || t.IPVersion == IPVersionBoth
+}
+
+// fanOutLabels are the labels netpulse sets on each target a fan-out target
+// expands into.
+func (t *Target) fanOutLabels() []string {
+	var out []string
+	if t.FanOut {
+		out = append(out, InstanceLabel)
+	}
+	if t.IPVersion == IPVersionBoth {
+		out = append(out, IPVersionLabel)
+	}
+	return out
+}
+
+// fanOutSource names the scheduler source owning the targets of a fan-out
+// target.
+func fanOutSource(name string) string {
+	return "fan_out/" + name
+}
+
+// runFanOut keeps the expanded targets of a fan-out target running until ctx
+// is done. A failed lookup keeps the previous addresses; a name that no
+// longer exists has none.
+func (s *scheduler) runFanOut(ctx context.Context, parent Target) {
+	ticker := time.NewTicker(FanOutRefreshInterval)
+	defer ticker.Stop()
+
+	source := fanOutSource(parent.Name)
+	var last []Target
+	for {
+		lookupCtx, cancel := context.WithTimeout(ctx, DNSLookupTimeout)
+		ts, err := fanOutTargets(lookupCtx, parent)
+		cancel()
+
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			ts, err = nil, nil
+		}
+		if err != nil && ctx.Err() == nil {
+			fmt.Printf("Error resolving fan-out target %s: %v\n", parent.Name, err)
+		} else {
+			last = ts
+		}
+
+		s.mu.Lock()
+		// a stopped or restarted parent has already dropped its targets
+		if ctx.Err() != nil {
+			s.mu.Unlock()
+			return
+		}
+		s.replace(source, last)
+		s.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// fanOutTargets expands parent. With fan_out it resolves the URL host and
+// returns one target per address, named and labelled by the address like DNS
+// A record discovery. A dual-stack target without fan_out yields one target
+// per address family, named and labelled by the family.
+func fanOutTargets(ctx context.Context, parent Target) ([]Target, error) {
+	if !parent.FanOut {
+		return []Target{
+			fanOutTarget(parent, IPVersion4, "", IPVersion4),
+			fanOutTarget(parent, IPVersion6, "", IPVersion6),
+		}, nil
+	}
+
+	u, err := url.Parse(parent.URL)
+	if err != nil {
+		return nil, err
+	}
+	_, port, _ := net.SplitHostPort(hostPort(u))
+
+	network := "ip"
+	switch parent.IPVersion {
+	case IPVersion4:
+		network = "ip4"
+	case IPVersion6:
+		network = "ip6"
+	}
+
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, network, u.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	for i := range addrs {
+		addrs[i] = addrs[i].Unmap()
+	}
+	slices.SortFunc(addrs, func(a, b netip.Addr) int { return a.Compare(b) })
+
+	var out []Target
+	for _, addr := range slices.Compact(addrs) {
+		instance := net.JoinHostPort(addr.String(), port)
+		t := fanOutTarget(parent, instance, instance, addrFamily(addr))
+		if len(t.Name) > MaxNameLength {
+			fmt.Printf("Skipping address %s of %s: name is longer than %d characters\n",
+				instance, parent.Name, MaxNameLength)
+			continue
+		}
+		out = append(out, t)
+	}
+	return out, nil
+}
+
+// fanOutTarget derives one expanded target from parent, pinned to address
+// when set and to the given address family.
+func fanOutTarget(parent Target, suffix, address, family string) Target {
+	t := parent
+	t.Name = parent.Name + "@" + suffix
+	t.Address = address
+	t.IPVersion = family
+	t.FanOut = false
+	t.Paused = false
+
+	t.Labels = make(map[string]string, len(parent.Labels)+2)
+	for k, v := range parent.Labels {
+		t.Labels[k] = v
+	}
+	if parent.FanOut {
+		t.Labels[InstanceLabel] = address
+	}
+	if parent.IPVersion == IPVersionBoth {
+		t.Labels[IPVersionLabel] = family
+	}
+	return t
+}
+
+// dialNetwork returns the network to dial over proto, "tcp" or "udp", for
+// an address family.
+func dialNetwork(proto, ipVersion string) string {
+	switch ipVersion {
+	case IPVersion4:
+		return proto + "4"
+	case IPVersion6:
+		return proto + "6"
+	}
+	return proto
+}
+
+func addrFamily(addr netip.Addr) string {
+	if addr.Unmap().Is4() {
+		return IPVersion4
+	}
+	return IPVersion6
+}
@@ -0,0 +1,170 @@
+// Copyright (c) 2025 Dakhil Y.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"go.yaml.in/yaml/v2"
+)
+
+// FileSDConfig discovers targets from files in the Prometheus file_sd format:
+// a list of {"targets": [...], "labels": {...}} groups in JSON or YAML.
+type FileSDConfig struct {
+	Files           []string `yaml:"files"`
+	RefreshInterval Duration `yaml:"refresh_interval"`
+	DiscoveryTarget `yaml:",inline"`
+}
+
+func (c *FileSDConfig) applyDefaults() {
+	if c.RefreshInterval == 0 {
+		c.RefreshInterval = Duration(DefaultRefreshInterval)
+	}
+	c.DiscoveryTarget.applyDefaults()
+}
+
+func (c FileSDConfig) validate() error {
+	if len(c.Files) == 0 {
+		return errors.New("files is required")
+	}
+	for _, pattern := range c.Files {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		switch filepath.Ext(pattern) {
+		case ".json", ".yml", ".yaml":
+		default:
+			return fmt.Errorf("pattern %q must end in .json, .yml or .yaml", pattern)
+		}
+	}
+	if c.RefreshInterval <= 0 {
+		return errors.New("refresh_interval must be positive")
+	}
+	return c.DiscoveryTarget.validate()
+}
+
+// fileSD polls the configured globs and re-reads files whose modification
+// time changed. Files that fail to parse keep their last good targets.
+type fileSD struct {
+	name   string
+	cfg    FileSDConfig
+	naming NamingConfig
+
+	files map[string]fileSDEntry
+}
+
+type fileSDEntry struct {
+	modTime time.Time
+	targets []Target
+}
+
+func newFileSD(name string, cfg FileSDConfig, naming NamingConfig) *fileSD {
+	return &fileSD{
+		name:   name,
+		cfg:    cfg,
+		naming: naming,
+		files:  make(map[string]fileSDEntry),
+	}
+}
+
+func (d *fileSD) source() string {
+	return d.name
+}
+
+func (d *fileSD) run(ctx context.Context, update func([]Target)) {
+	ticker := time.NewTicker(time.Duration(d.cfg.RefreshInterval))
+	defer ticker.Stop()
+
+	for {
+		if d.refresh() {
+			update(d.targets())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// refresh rescans the globs and reports whether any file was added,
+// removed or changed.
+func (d *fileSD) refresh() bool {
+	current := make(map[string]bool)
+	for _, pattern := range d.cfg.Files {
+		matches, _ := filepath.Glob(pattern)
+		for _, m := range matches {
+			current[m] = true
+		}
+	}
+
+	changed := false
+	for path := range d.files {
+		if !current[path] {
+			delete(d.files, path)
+			changed = true
+		}
+	}
+
+	for path := range current {
+		fi, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if e, ok := d.files[path]; ok && e.modTime.Equal(fi.ModTime()) {
+			continue
+		}
+
+		groups, err := readFileSD(path)
+		if err != nil {
+			fmt.Printf("Error reading %s in %s: %v\n", path, d.name, err)
+			continue
+		}
+		d.files[path] = fileSDEntry{
+			modTime: fi.ModTime(),
+			targets: d.cfg.targets(d.name, groups, d.naming),
+		}
+		changed = true
+	}
+	return changed
+}
+
+func (d *fileSD) targets() []Target {
+	paths := make([]string, 0, len(d.files))
+	for path := range d.files {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+
+	var out []Target
+	for _, path := range paths {
+		out = append(out, d.files[path].targets...)
+	}
+	return out
+}
+
+func readFileSD(path string) ([]targetGroup, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []targetGroup
+	switch filepath.Ext(path) {
+	case ".json":
+		err = json.Unmarshal(data, &groups)
+	default:
+		err = yaml.UnmarshalStrict(data, &groups)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
@@ -0,0 +1,202 @@
+// Copyright (c) 2025 Dakhil Y.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// GRPCOptions configures a grpc:// (plaintext) or grpcs:// (TLS) target,
+// probed with grpc.health.v1.Health/Check.
+type GRPCOptions struct {
+	// Service is the service name sent in the health check. Empty checks
+	// the server as a whole.
+	Service            string            `yaml:"service" json:"service,omitempty"`
+	Metadata           map[string]string `yaml:"metadata" json:"metadata,omitempty"`
+	ServerName         string            `yaml:"server_name" json:"server_name,omitempty"`
+	InsecureSkipVerify bool              `yaml:"insecure_skip_verify" json:"insecure_skip_verify,omitempty"`
+}
+
+func (o *GRPCOptions) validate(t *Target) error {
+	u, err := url.Parse(t.URL)
+	if err != nil {
+		return err
+	}
+	if _, _, err := net.SplitHostPort(u.Host); err != nil {
+		return errors.New("grpc url must include a port")
+	}
+	if u.Path != "" && u.Path != "/" {
+		return errors.New("grpc url must not have a path, set grpc.service instead")
+	}
+	if o != nil && (o.ServerName != "" || o.InsecureSkipVerify) && u.Scheme != "grpcs" {
+		return errors.New("grpc.server_name and grpc.insecure_skip_verify require a grpcs url")
+	}
+	return nil
+}
+
+// grpcProber keeps one client connection per target, reconnecting at most
+// once per probe interval. Dial and handshake errors are remembered so that
+// an Unavailable status can be classified like an HTTP transport error.
+type grpcProber struct {
+	target Target
+	opts   GRPCOptions
+	health healthpb.HealthClient
+
+	mu      sync.Mutex
+	connErr error
+}
+
+func newGRPCProber(t Target) (probeFunc, func()) {
+	p := &grpcProber{target: t}
+	if t.GRPC != nil {
+		p.opts = *t.GRPC
+	}
+
+	u, _ := url.Parse(t.URL)
+	var creds credentials.TransportCredentials = insecure.NewCredentials()
+	if u.Scheme == "grpcs" {
+		creds = credentials.NewTLS(&tls.Config{
+			ServerName:         p.opts.ServerName,
+			InsecureSkipVerify: p.opts.InsecureSkipVerify,
+		})
+	}
+
+	interval := time.Duration(t.Interval)
+	dial := dialContext(t)
+	conn, err := grpc.NewClient("passthrough:///"+u.Host,
+		grpc.WithTransportCredentials(recordingCreds{creds, p}),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			c, err := dial(ctx, "tcp", addr)
+			if err != nil {
+				p.setConnErr(err)
+			}
+			return c, err
+		}),
+		grpc.WithConnectParams(grpc.ConnectParams{
+			Backoff:           backoff.Config{BaseDelay: interval, Multiplier: 1, MaxDelay: interval},
+			MinConnectTimeout: httpClient.Timeout,
+		}),
+		grpc.WithUserAgent("netpulse"),
+	)
+	if err != nil {
+		// rejected by validate
+		return func(context.Context) (Result, error) {
+			return Result{
+				Target:      t.Name,
+				Time:        time.Now(),
+				Status:      "transport_error",
+				ErrorReason: FailureUnknown,
+			}, err
+		}, func() {}
+	}
+
+	p.health = healthpb.NewHealthClient(conn)
+	return p.probe, func() { conn.Close() }
+}
+
+func (p *grpcProber) probe(ctx context.Context) (Result, error) {
+	res := Result{
+		Target:      p.target.Name,
+		Time:        time.Now(),
+		Status:      "success",
+		ErrorReason: FailureNone,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, httpClient.Timeout)
+	defer cancel()
+	if len(p.opts.Metadata) > 0 {
+		ctx = metadata.NewOutgoingContext(ctx, metadata.New(p.opts.Metadata))
+	}
+
+	resp, err := p.health.Check(ctx, &healthpb.HealthCheckRequest{Service: p.opts.Service})
+	res.Latency = time.Since(res.Time).Seconds()
+
+	if err != nil {
+		code := status.Code(err)
+		switch {
+		case code == codes.Unavailable && p.getConnErr() != nil:
+			err = p.getConnErr()
+			res.Status = "transport_error"
+			res.ErrorReason = classifyTransportError(err)
+			return res, err
+		case code == codes.DeadlineExceeded:
+			res.Status = "transport_error"
+			res.ErrorReason = FailureTimeout
+			return res, err
+		case code == codes.Canceled:
+			res.Status = "transport_error"
+			res.ErrorReason = FailureContextCanceled
+			return res, err
+		}
+		res.Status = "grpc_error"
+		res.ErrorReason = classifyGRPCStatus(code)
+		return res, nil
+	}
+
+	if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		res.Status = "grpc_error"
+		res.ErrorReason = FailureGRPCNotServing
+	}
+	return res, nil
+}
+
+func (p *grpcProber) setConnErr(err error) {
+	p.mu.Lock()
+	p.connErr = err
+	p.mu.Unlock()
+}
+
+func (p *grpcProber) getConnErr() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.connErr
+}
+
+// recordingCreds records the outcome of each TLS handshake on the prober.
+// A successful plaintext or TLS connection clears the last error.
+type recordingCreds struct {
+	credentials.TransportCredentials
+	p *grpcProber
+}
+
+func (c recordingCreds) ClientHandshake(ctx context.Context, authority string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	conn, info, err := c.TransportCredentials.ClientHandshake(ctx, authority, conn)
+	c.p.setConnErr(err)
+	return conn, info, err
+}
+
+func (c recordingCreds) Clone() credentials.TransportCredentials {
+	return recordingCreds{c.TransportCredentials.Clone(), c.p}
+}
+
+// classifyGRPCStatus maps a gRPC status code onto the failure taxonomy,
+// following the HTTP status each code corresponds to: codes the caller can
+// fix are client errors like 4xx, the rest server errors like 5xx.
+func classifyGRPCStatus(code codes.Code) string {
+	switch code {
+	case codes.OK:
+		return FailureNone
+	case codes.Unavailable:
+		return FailureGRPCUnavailable
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange,
+		codes.Unauthenticated, codes.PermissionDenied, codes.NotFound,
+		codes.AlreadyExists, codes.Aborted, codes.ResourceExhausted:
+		return FailureGRPCClientError
+	default:
+		return FailureGRPCServerError
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright (c) 2025 Dakhil Y.
+package main
+
+import (
+	"cmp"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+)
+
+// startHealthServer serves grpc.health.v1 on a loopback port with a serving
+// and a not serving service, and passes the metadata of every call to md.
+func startHealthServer(t *testing.T, md chan<- metadata.MD) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (any, error) {
+		if md != nil {
+			in, _ := metadata.FromIncomingContext(ctx)
+			md <- in
+		}
+		return handler(ctx, req)
+	}))
+	hs := health.NewServer()
+	hs.SetServingStatus("up", healthpb.HealthCheckResponse_SERVING)
+	hs.SetServingStatus("down", healthpb.HealthCheckResponse_NOT_SERVING)
+	healthpb.RegisterHealthServer(srv, hs)
+
+	go srv.Serve(l)
+	t.Cleanup(srv.Stop)
+	return l.Addr().String()
+}
+
+func probeGRPC(t *testing.T, target Target) (Result, error) {
+	t.Helper()
+	target.Name = target.URL
+	target.Interval = Duration(time.Second)
+	if err := target.validate(); err != nil {
+		t.Fatal(err)
+	}
+	run, closeProber := newGRPCProber(target)
+	defer closeProber()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return run(ctx)
+}
+
+func TestGRPCProbe(t *testing.T) {
+	addr := startHealthServer(t, nil)
+
+	tests := []struct {
+		service    string
+		wantStatus string
+		wantReason string
+	}{
+		{service: "", wantStatus: "success", wantReason: FailureNone},
+		{service: "up", wantStatus: "success", wantReason: FailureNone},
+		{service: "down", wantStatus: "grpc_error", wantReason: FailureGRPCNotServing},
+		{service: "unknown", wantStatus: "grpc_error", wantReason: FailureGRPCClientError},
+	}
+	for _, tt := range tests {
+		t.Run(cmp.Or(tt.service, "server"), func(t *testing.T) {
+			res, err := probeGRPC(t, Target{URL: "grpc://" + addr, GRPC: &GRPCOptions{Service: tt.service}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Status != tt.wantStatus || res.ErrorReason != tt.wantReason {
+				t.Errorf("result = %s/%s, want %s/%s", res.Status, res.ErrorReason, tt.wantStatus, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestGRPCProbeMetadata(t *testing.T) {
+	md := make(chan metadata.MD, 1)
+	addr := startHealthServer(t, md)
+
+	res, err := probeGRPC(t, Target{
+		URL:  "grpc://" + addr,
+		GRPC: &GRPCOptions{Metadata: map[string]string{"authorization": "Bearer token", "x-tenant": "blue"}},
+	})
+	if err != nil || !res.Success() {
+		t.Fatalf("result = %s/%s, %v", res.Status, res.ErrorReason, err)
+	}
+
+	in := <-md
+	for k, want := range map[string]string{"authorization": "Bearer token", "x-tenant": "blue"} {
+		if got := in.Get(k); len(got) != 1 || got[0] != want {
+			t.Errorf("metadata %s = %v, want %q", k, got, want)
+		}
+	}
+	if got := in.Get("user-agent"); len(got) != 1 || !strings.HasPrefix(got[0], "netpulse") {
+		t.Errorf("user-agent = %v, want netpulse", got)
+	}
+}
+
+func TestGRPCProbeDialFailure(t *testing.T) {
+	// a port nothing listens on any more
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	res, err := probeGRPC(t, Target{URL: "grpc://" + addr})
+	if err == nil {
+		t.Fatal("probe succeeded")
+	}
+	if res.Status != "transport_error" || res.ErrorReason != FailureConnectionRefused {
+		t.Errorf("result = %s/%s, want transport_error/%s", res.Status, res.ErrorReason, FailureConnectionRefused)
+	}
+}
+
+func TestClassifyGRPCStatus(t *testing.T) {
+	tests := map[codes.Code]string{
+		codes.OK:                FailureNone,
+		codes.Unavailable:       FailureGRPCUnavailable,
+		codes.NotFound:          FailureGRPCClientError,
+		codes.PermissionDenied:  FailureGRPCClientError,
+		codes.ResourceExhausted: FailureGRPCClientError,
+		codes.Internal:          FailureGRPCServerError,
+		codes.Unimplemented:     FailureGRPCServerError,
+		codes.Unknown:           FailureGRPCServerError,
+	}
+	for code, want := range tests {
+		if got := classifyGRPCStatus(code); got != want {
+			t.Errorf("classifyGRPCStatus(%s) = %s, want %s", code, got, want)
+		}
+	}
+}
@@ -0,0 +1,368 @@
+// Copyright (c) 2025 Dakhil Y.
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	discoverylisters "k8s.io/client-go/listers/discovery/v1"
+	networkinglisters "k8s.io/client-go/listers/networking/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	KubeRoleService   = "service"
+	KubeRoleEndpoints = "endpoints"
+	KubeRoleIngress   = "ingress"
+
+	// Annotations read from Services and Ingresses.
+	ProbeAnnotation  = "netpulse.io/probe"
+	SchemeAnnotation = "netpulse.io/scheme"
+	PathAnnotation   = "netpulse.io/path"
+	PortAnnotation   = "netpulse.io/port"
+
+	KubeResyncInterval = 10 * time.Minute
+)
+
+// KubernetesSDConfig discovers targets from Services, the endpoints behind
+// them, or Ingresses. Only objects carrying every annotation in Annotations
+// are probed, by default netpulse.io/probe: "true".
+type KubernetesSDConfig struct {
+	Role          string            `yaml:"role"`
+	Kubeconfig    string            `yaml:"kubeconfig"`
+	Namespaces    []string          `yaml:"namespaces"`
+	LabelSelector string            `yaml:"label_selector"`
+	Annotations   map[string]string `yaml:"annotations"`
+
+	DiscoveryTarget `yaml:",inline"`
+}
+
+func (c *KubernetesSDConfig) applyDefaults() {
+	if c.Role == "" {
+		c.Role = KubeRoleService
+	}
+	if c.Annotations == nil {
+		c.Annotations = map[string]string{ProbeAnnotation: "true"}
+	}
+	c.DiscoveryTarget.applyDefaults()
+}
+
+func (c KubernetesSDConfig) validate() error {
+	switch c.Role {
+	case KubeRoleService, KubeRoleEndpoints, KubeRoleIngress:
+	default:
+		return fmt.Errorf("unsupported role %q, want service, endpoints or ingress", c.Role)
+	}
+	if _, err := labels.Parse(c.LabelSelector); err != nil {
+		return fmt.Errorf("invalid label_selector: %w", err)
+	}
+	return c.DiscoveryTarget.validate()
+}
+
+// newKubeClient uses the kubeconfig file when set and the in-cluster service
+// account otherwise.
+func newKubeClient(kubeconfig string) (kubernetes.Interface, error) {
+	var cfg *rest.Config
+	var err error
+	if kubeconfig != "" {
+		cfg, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	} else {
+		cfg, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("kubernetes client config: %w", err)
+	}
+	return kubernetes.NewForConfig(cfg)
+}
+
+// kubeSD watches the API server through shared informers and rebuilds its
+// target list from the informer caches on every change.
+type kubeSD struct {
+	name     string
+	cfg      KubernetesSDConfig
+	naming   NamingConfig
+	client   kubernetes.Interface
+	selector labels.Selector
+
+	services       []corelisters.ServiceLister
+	endpointSlices []discoverylisters.EndpointSliceLister
+	ingresses      []networkinglisters.IngressLister
+}
+
+func newKubeSD(name string, cfg KubernetesSDConfig, naming NamingConfig, client kubernetes.Interface) *kubeSD {
+	selector, err := labels.Parse(cfg.LabelSelector)
+	if err != nil {
+		// rejected by validate
+		selector = labels.Everything()
+	}
+	return &kubeSD{
+		name:     name,
+		cfg:      cfg,
+		naming:   naming,
+		client:   client,
+		selector: selector,
+	}
+}
+
+func (d *kubeSD) source() string {
+	return d.name
+}
+
+func (d *kubeSD) run(ctx context.Context, update func([]Target)) {
+	changed := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(any) { notify() },
+		UpdateFunc: func(any, any) { notify() },
+		DeleteFunc: func(any) { notify() },
+	}
+
+	namespaces := d.cfg.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+
+	var factories []informers.SharedInformerFactory
+	for _, ns := range namespaces {
+		f := informers.NewSharedInformerFactoryWithOptions(d.client, KubeResyncInterval, informers.WithNamespace(ns))
+		factories = append(factories, f)
+
+		switch d.cfg.Role {
+		case KubeRoleService, KubeRoleEndpoints:
+			svc := f.Core().V1().Services()
+			svc.Informer().AddEventHandler(handler)
+			d.services = append(d.services, svc.Lister())
+			if d.cfg.Role == KubeRoleEndpoints {
+				es := f.Discovery().V1().EndpointSlices()
+				es.Informer().AddEventHandler(handler)
+				d.endpointSlices = append(d.endpointSlices, es.Lister())
+			}
+		case KubeRoleIngress:
+			ing := f.Networking().V1().Ingresses()
+			ing.Informer().AddEventHandler(handler)
+			d.ingresses = append(d.ingresses, ing.Lister())
+		}
+	}
+
+	for _, f := range factories {
+		f.Start(ctx.Done())
+	}
+	for _, f := range factories {
+		for typ, ok := range f.WaitForCacheSync(ctx.Done()) {
+			if !ok {
+				fmt.Printf("Error syncing %v cache in %s\n", typ, d.name)
+			}
+		}
+	}
+
+	for {
+		update(d.targets())
+
+		select {
+		case <-ctx.Done():
+			for _, f := range factories {
+				f.Shutdown()
+			}
+			return
+		case <-changed:
+		}
+	}
+}
+
+func (d *kubeSD) targets() []Target {
+	var out []Target
+	switch d.cfg.Role {
+	case KubeRoleService, KubeRoleEndpoints:
+		for _, lister := range d.services {
+			svcs, _ := lister.List(d.selector)
+			for _, svc := range svcs {
+				if !d.annotated(svc.Annotations) {
+					continue
+				}
+				if d.cfg.Role == KubeRoleService {
+					out = append(out, d.serviceTargets(svc)...)
+				} else {
+					out = append(out, d.endpointTargets(svc)...)
+				}
+			}
+		}
+	case KubeRoleIngress:
+		for _, lister := range d.ingresses {
+			ings, _ := lister.List(d.selector)
+			for _, ing := range ings {
+				if d.annotated(ing.Annotations) {
+					out = append(out, d.ingressTargets(ing)...)
+				}
+			}
+		}
+	}
+
+	slices.SortFunc(out, func(a, b Target) int { return strings.Compare(a.Name, b.Name) })
+	return out
+}
+
+func (d *kubeSD) annotated(annotations map[string]string) bool {
+	for k, v := range d.cfg.Annotations {
+		if annotations[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// metaLabels maps the scheme and path annotations onto the meta labels
+// understood by DiscoveryTarget.
+func metaLabels(annotations map[string]string, extra map[string]string) map[string]string {
+	out := map[string]string{
+		SchemeLabel: annotations[SchemeAnnotation],
+		PathLabel:   annotations[PathAnnotation],
+	}
+	for k, v := range extra {
+		out[k] = v
+	}
+	return out
+}
+
+// probedPort reports whether a port is selected by the port annotation. All
+// ports are probed when it is absent.
+func probedPort(annotations map[string]string, name string, port int32) bool {
+	want, ok := annotations[PortAnnotation]
+	if !ok {
+		return true
+	}
+	return want == name || want == strconv.Itoa(int(port))
+}
+
+func (d *kubeSD) serviceTargets(svc *corev1.Service) []Target {
+	host := svc.Name + "." + svc.Namespace + ".svc"
+	if svc.Spec.Type == corev1.ServiceTypeExternalName {
+		host = svc.Spec.ExternalName
+	}
+
+	var out []Target
+	for _, p := range svc.Spec.Ports {
+		if p.Protocol != corev1.ProtocolTCP || !probedPort(svc.Annotations, p.Name, p.Port) {
+			continue
+		}
+		addr := net.JoinHostPort(host, strconv.Itoa(int(p.Port)))
+		t := d.cfg.newTarget(addr, metaLabels(svc.Annotations, map[string]string{
+			"namespace": svc.Namespace,
+			"service":   svc.Name,
+		}), d.naming)
+		out = d.appendValid(out, t)
+	}
+	return out
+}
+
+// endpointTargets yields one target per ready endpoint address and port. The
+// URL keeps the service DNS name and the connection is pinned to the
+// endpoint, as with DNS A record discovery.
+func (d *kubeSD) endpointTargets(svc *corev1.Service) []Target {
+	req, err := labels.NewRequirement(discoveryv1.LabelServiceName, "=", []string{svc.Name})
+	if err != nil {
+		return nil
+	}
+	selector := labels.NewSelector().Add(*req)
+
+	var out []Target
+	for _, lister := range d.endpointSlices {
+		eps, _ := lister.EndpointSlices(svc.Namespace).List(selector)
+		for _, es := range eps {
+			if es.AddressType == discoveryv1.AddressTypeFQDN {
+				continue
+			}
+			for _, p := range es.Ports {
+				if p.Port == nil || (p.Protocol != nil && *p.Protocol != corev1.ProtocolTCP) {
+					continue
+				}
+				name := ""
+				if p.Name != nil {
+					name = *p.Name
+				}
+				if !probedPort(svc.Annotations, name, *p.Port) {
+					continue
+				}
+				port := strconv.Itoa(int(*p.Port))
+				host := net.JoinHostPort(svc.Name+"."+svc.Namespace+".svc", port)
+
+				for _, ep := range es.Endpoints {
+					if ep.Conditions.Ready != nil && !*ep.Conditions.Ready {
+						continue
+					}
+					for _, ip := range ep.Addresses {
+						instance := net.JoinHostPort(ip, port)
+						extra := map[string]string{
+							"namespace":   svc.Namespace,
+							"service":     svc.Name,
+							InstanceLabel: instance,
+						}
+						if ep.TargetRef != nil && ep.TargetRef.Kind == "Pod" {
+							extra["pod"] = ep.TargetRef.Name
+						}
+
+						t := d.cfg.newTarget(host, metaLabels(svc.Annotations, extra), d.naming)
+						t.Address = instance
+						t.Name += "@" + instance
+						out = d.appendValid(out, t)
+					}
+				}
+			}
+		}
+	}
+	return out
+}
+
+// ingressTargets yields one target per rule host, using https when the host
+// is covered by the ingress TLS section.
+func (d *kubeSD) ingressTargets(ing *networkingv1.Ingress) []Target {
+	tlsHosts := make(map[string]bool)
+	for _, tls := range ing.Spec.TLS {
+		for _, h := range tls.Hosts {
+			tlsHosts[h] = true
+		}
+	}
+
+	var out []Target
+	for _, rule := range ing.Spec.Rules {
+		if rule.Host == "" {
+			continue
+		}
+		meta := metaLabels(ing.Annotations, map[string]string{
+			"namespace": ing.Namespace,
+			"ingress":   ing.Name,
+		})
+		if meta[SchemeLabel] == "" && tlsHosts[rule.Host] {
+			meta[SchemeLabel] = "https"
+		}
+		out = d.appendValid(out, d.cfg.newTarget(rule.Host, meta, d.naming))
+	}
+	return out
+}
+
+func (d *kubeSD) appendValid(out []Target, t Target) []Target {
+	if err := t.validate(); err != nil {
+		fmt.Printf("Skipping target %s from %s: %v\n", t.URL, d.name, err)
+		return out
+	}
+	return append(out, t)
+}
@@ -0,0 +1,258 @@
+// Copyright (c) 2025 Dakhil Y.
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// kubeTargets runs a kubeSD against a fake API server holding objects and
+// returns the first target list it reports.
+func kubeTargets(t *testing.T, cfg KubernetesSDConfig, objects ...runtime.Object) []Target {
+	t.Helper()
+	cfg.applyDefaults()
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	d := newKubeSD("kubernetes_sd_configs[0]", cfg, NamingConfig{}, fake.NewClientset(objects...))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	updates := make(chan []Target, 1)
+	go func() {
+		defer close(done)
+		d.run(ctx, func(ts []Target) {
+			select {
+			case updates <- ts:
+			default:
+			}
+		})
+	}()
+
+	select {
+	case ts := <-updates:
+		return ts
+	case <-time.After(10 * time.Second):
+		t.Fatal("no targets reported")
+		return nil
+	}
+}
+
+func targetURLs(ts []Target) []string {
+	out := make([]string, 0, len(ts))
+	for _, t := range ts {
+		out = append(out, t.URL)
+	}
+	return out
+}
+
+func kubeService(namespace, name string, annotations map[string]string, ports ...corev1.ServicePort) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Annotations: annotations},
+		Spec:       corev1.ServiceSpec{Ports: ports},
+	}
+}
+
+func servicePort(name string, port int32, protocol corev1.Protocol) corev1.ServicePort {
+	return corev1.ServicePort{Name: name, Port: port, Protocol: protocol}
+}
+
+var kubeProbed = map[string]string{ProbeAnnotation: "true"}
+
+func TestKubeSDServices(t *testing.T) {
+	objects := []runtime.Object{
+		kubeService("prod", "web", kubeProbed,
+			servicePort("http", 80, corev1.ProtocolTCP),
+			servicePort("metrics", 9090, corev1.ProtocolTCP),
+			servicePort("dns", 53, corev1.ProtocolUDP)),
+		kubeService("prod", "hidden", nil, servicePort("http", 80, corev1.ProtocolTCP)),
+		kubeService("prod", "off", map[string]string{ProbeAnnotation: "false"},
+			servicePort("http", 80, corev1.ProtocolTCP)),
+		kubeService("staging", "api", map[string]string{
+			ProbeAnnotation:  "true",
+			SchemeAnnotation: "https",
+			PathAnnotation:   "/healthz",
+		}, servicePort("https", 443, corev1.ProtocolTCP)),
+	}
+
+	ts := kubeTargets(t, KubernetesSDConfig{}, objects...)
+	want := []string{
+		"http://web.prod.svc:80/",
+		"http://web.prod.svc:9090/",
+		"https://api.staging.svc:443/healthz",
+	}
+	if got := targetURLs(ts); !slices.Equal(got, want) {
+		t.Fatalf("urls = %v, want %v", got, want)
+	}
+	if got := ts[0].Labels; got["namespace"] != "prod" || got["service"] != "web" {
+		t.Errorf("labels = %v, want namespace prod and service web", got)
+	}
+	if _, ok := ts[0].Labels[SchemeLabel]; ok {
+		t.Errorf("meta label %s exported", SchemeLabel)
+	}
+}
+
+func TestKubeSDEndpoints(t *testing.T) {
+	ready, notReady := true, false
+	port, name := int32(8080), "http"
+	slice := &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "prod",
+			Name:      "web-abc",
+			Labels:    map[string]string{discoveryv1.LabelServiceName: "web"},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Ports:       []discoveryv1.EndpointPort{{Name: &name, Port: &port}},
+		Endpoints: []discoveryv1.Endpoint{
+			{
+				Addresses:  []string{"10.0.0.1"},
+				Conditions: discoveryv1.EndpointConditions{Ready: &ready},
+				TargetRef:  &corev1.ObjectReference{Kind: "Pod", Name: "web-1"},
+			},
+			{Addresses: []string{"10.0.0.2"}, Conditions: discoveryv1.EndpointConditions{Ready: &notReady}},
+			// no condition counts as ready
+			{Addresses: []string{"10.0.0.3"}},
+		},
+	}
+	other := slice.DeepCopy()
+	other.Name = "other-abc"
+	other.Labels = map[string]string{discoveryv1.LabelServiceName: "other"}
+
+	ts := kubeTargets(t, KubernetesSDConfig{Role: KubeRoleEndpoints},
+		kubeService("prod", "web", kubeProbed, servicePort("http", 80, corev1.ProtocolTCP)),
+		kubeService("prod", "other", nil, servicePort("http", 80, corev1.ProtocolTCP)),
+		slice, other)
+
+	var names, addresses []string
+	for _, tg := range ts {
+		names = append(names, tg.Name)
+		addresses = append(addresses, tg.Address)
+		if tg.URL != "http://web.prod.svc:8080/" {
+			t.Errorf("%s: url = %s, want the service name", tg.Name, tg.URL)
+		}
+		if tg.Labels[InstanceLabel] != tg.Address {
+			t.Errorf("%s: instance = %q, want %q", tg.Name, tg.Labels[InstanceLabel], tg.Address)
+		}
+	}
+	wantNames := []string{"http://web.prod.svc:8080/@10.0.0.1:8080", "http://web.prod.svc:8080/@10.0.0.3:8080"}
+	if !slices.Equal(names, wantNames) {
+		t.Fatalf("names = %v, want %v", names, wantNames)
+	}
+	if want := []string{"10.0.0.1:8080", "10.0.0.3:8080"}; !slices.Equal(addresses, want) {
+		t.Errorf("addresses = %v, want %v", addresses, want)
+	}
+	if got := ts[0].Labels["pod"]; got != "web-1" {
+		t.Errorf("pod = %q, want web-1", got)
+	}
+	if _, ok := ts[1].Labels["pod"]; ok {
+		t.Errorf("pod label set on an endpoint without a pod")
+	}
+}
+
+func TestKubeSDIngresses(t *testing.T) {
+	rule := func(host string) networkingv1.IngressRule {
+		return networkingv1.IngressRule{Host: host}
+	}
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "prod", Name: "shop", Annotations: kubeProbed},
+		Spec: networkingv1.IngressSpec{
+			TLS:   []networkingv1.IngressTLS{{Hosts: []string{"shop.example.com"}}},
+			Rules: []networkingv1.IngressRule{rule("shop.example.com"), rule("admin.example.com"), rule("")},
+		},
+	}
+	hidden := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "prod", Name: "hidden"},
+		Spec:       networkingv1.IngressSpec{Rules: []networkingv1.IngressRule{rule("hidden.example.com")}},
+	}
+
+	ts := kubeTargets(t, KubernetesSDConfig{Role: KubeRoleIngress}, ingress, hidden)
+	want := []string{"http://admin.example.com/", "https://shop.example.com/"}
+	if got := targetURLs(ts); !slices.Equal(got, want) {
+		t.Fatalf("urls = %v, want %v", got, want)
+	}
+	if got := ts[0].Labels["ingress"]; got != "shop" {
+		t.Errorf("ingress = %q, want shop", got)
+	}
+}
+
+func TestKubeSDFilters(t *testing.T) {
+	objects := []runtime.Object{
+		kubeService("prod", "web", map[string]string{"team": "web"}, servicePort("http", 80, corev1.ProtocolTCP)),
+		kubeService("prod", "db", map[string]string{"team": "db"}, servicePort("http", 80, corev1.ProtocolTCP)),
+		kubeService("staging", "web", map[string]string{"team": "web"}, servicePort("http", 80, corev1.ProtocolTCP)),
+	}
+
+	tests := []struct {
+		name string
+		cfg  KubernetesSDConfig
+		want []string
+	}{
+		{
+			name: "default annotation",
+			cfg:  KubernetesSDConfig{},
+			want: []string{},
+		},
+		{
+			name: "custom annotation",
+			cfg:  KubernetesSDConfig{Annotations: map[string]string{"team": "web"}},
+			want: []string{"http://web.prod.svc:80/", "http://web.staging.svc:80/"},
+		},
+		{
+			name: "namespace",
+			cfg:  KubernetesSDConfig{Annotations: map[string]string{"team": "web"}, Namespaces: []string{"staging"}},
+			want: []string{"http://web.staging.svc:80/"},
+		},
+		{
+			name: "no annotation required",
+			cfg:  KubernetesSDConfig{Annotations: map[string]string{}, Namespaces: []string{"prod"}},
+			want: []string{"http://db.prod.svc:80/", "http://web.prod.svc:80/"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := targetURLs(kubeTargets(t, tt.cfg, objects...)); !slices.Equal(got, tt.want) {
+				t.Errorf("urls = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKubeSDPortAnnotation(t *testing.T) {
+	annotated := func(port string) map[string]string {
+		return map[string]string{ProbeAnnotation: "true", PortAnnotation: port}
+	}
+	ports := []corev1.ServicePort{
+		servicePort("http", 80, corev1.ProtocolTCP),
+		servicePort("admin", 8080, corev1.ProtocolTCP),
+	}
+
+	tests := []struct {
+		port string
+		want []string
+	}{
+		{port: "admin", want: []string{"http://web.prod.svc:8080/"}},
+		{port: "80", want: []string{"http://web.prod.svc:80/"}},
+		{port: "9090", want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.port, func(t *testing.T) {
+			ts := kubeTargets(t, KubernetesSDConfig{}, kubeService("prod", "web", annotated(tt.port), ports...))
+			if got := targetURLs(ts); !slices.Equal(got, tt.want) {
+				t.Errorf("urls = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,358 @@
+// Copyright (c) 2025 Dakhil Y.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/textproto"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Steps of a mail probe.
+const (
+	StepConnect      = "connect"
+	StepTLS          = "tls"
+	StepBanner       = "banner"
+	StepCapabilities = "capabilities"
+	StepStartTLS     = "starttls"
+
+	DefaultMailHostname = "netpulse"
+)
+
+// MailOptions configures an smtp, imap or pop3 target. The smtps, imaps and
+// pop3s schemes use implicit TLS; StartTLS upgrades a plaintext connection
+// and fails the probe when the server does not offer it.
+type MailOptions struct {
+	Banner             string `yaml:"banner" json:"banner,omitempty"`
+	StartTLS           bool   `yaml:"starttls" json:"starttls,omitempty"`
+	Hostname           string `yaml:"hostname" json:"hostname,omitempty"`
+	ServerName         string `yaml:"server_name" json:"server_name,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify" json:"insecure_skip_verify,omitempty"`
+}
+
+func (o *MailOptions) validate(t *Target) error {
+	if o == nil {
+		return nil
+	}
+	if _, err := regexp.Compile(o.Banner); err != nil {
+		return fmt.Errorf("invalid mail.banner: %w", err)
+	}
+	u, err := url.Parse(t.URL)
+	if err != nil {
+		return err
+	}
+	implicit := strings.HasSuffix(u.Scheme, "s")
+	if o.StartTLS && implicit {
+		return errors.New("mail.starttls cannot be used with implicit TLS")
+	}
+	if (o.ServerName != "" || o.InsecureSkipVerify) && !o.StartTLS && !implicit {
+		return errors.New("mail.server_name and mail.insecure_skip_verify require TLS")
+	}
+	return nil
+}
+
+// mailProtocol holds the commands that differ between SMTP, IMAP and POP3.
+type mailProtocol struct {
+	// greeting reads the server banner.
+	greeting func(c *textproto.Conn) (string, error)
+	// capabilities lists the extensions the server advertises.
+	capabilities func(c *textproto.Conn, hostname string) ([]string, error)
+	// startTLS asks the server to upgrade the connection.
+	startTLS func(c *textproto.Conn) error
+	quit     func(c *textproto.Conn)
+
+	startTLSCapability string
+}
+
+var mailProtocols = map[string]mailProtocol{
+	KindSMTP: {
+		greeting: func(c *textproto.Conn) (string, error) {
+			_, msg, err := c.ReadResponse(220)
+			return msg, err
+		},
+		capabilities: func(c *textproto.Conn, hostname string) ([]string, error) {
+			if err := c.PrintfLine("EHLO %s", hostname); err != nil {
+				return nil, err
+			}
+			_, msg, err := c.ReadResponse(250)
+			if err != nil {
+				return nil, err
+			}
+			// the first line greets the client
+			_, caps, _ := strings.Cut(msg, "\n")
+			return capabilityNames(strings.Split(caps, "\n")), nil
+		},
+		startTLS: func(c *textproto.Conn) error {
+			if err := c.PrintfLine("STARTTLS"); err != nil {
+				return err
+			}
+			_, _, err := c.ReadResponse(220)
+			return err
+		},
+		quit: func(c *textproto.Conn) {
+			if c.PrintfLine("QUIT") == nil {
+				c.ReadResponse(221)
+			}
+		},
+		startTLSCapability: "STARTTLS",
+	},
+
+	KindIMAP: {
+		greeting: func(c *textproto.Conn) (string, error) {
+			line, err := c.ReadLine()
+			if err != nil {
+				return "", err
+			}
+			if msg, ok := strings.CutPrefix(line, "* OK"); ok {
+				return strings.TrimSpace(msg), nil
+			}
+			if msg, ok := strings.CutPrefix(line, "* PREAUTH"); ok {
+				return strings.TrimSpace(msg), nil
+			}
+			return "", &mailError{line}
+		},
+		capabilities: func(c *textproto.Conn, _ string) ([]string, error) {
+			var caps []string
+			err := imapCommand(c, "n1", "CAPABILITY", func(line string) {
+				if rest, ok := strings.CutPrefix(line, "* CAPABILITY "); ok {
+					caps = append(caps, strings.Fields(strings.ToUpper(rest))...)
+				}
+			})
+			return caps, err
+		},
+		startTLS: func(c *textproto.Conn) error {
+			return imapCommand(c, "n2", "STARTTLS", nil)
+		},
+		quit: func(c *textproto.Conn) {
+			imapCommand(c, "n3", "LOGOUT", nil)
+		},
+		startTLSCapability: "STARTTLS",
+	},
+
+	KindPOP3: {
+		greeting: func(c *textproto.Conn) (string, error) {
+			return pop3Response(c)
+		},
+		capabilities: func(c *textproto.Conn, _ string) ([]string, error) {
+			if err := c.PrintfLine("CAPA"); err != nil {
+				return nil, err
+			}
+			if _, err := pop3Response(c); err != nil {
+				// CAPA is optional in POP3
+				var mailErr *mailError
+				if errors.As(err, &mailErr) {
+					return nil, nil
+				}
+				return nil, err
+			}
+			lines, err := c.ReadDotLines()
+			return capabilityNames(lines), err
+		},
+		startTLS: func(c *textproto.Conn) error {
+			if err := c.PrintfLine("STLS"); err != nil {
+				return err
+			}
+			_, err := pop3Response(c)
+			return err
+		},
+		quit: func(c *textproto.Conn) {
+			if c.PrintfLine("QUIT") == nil {
+				pop3Response(c)
+			}
+		},
+		startTLSCapability: "STLS",
+	},
+}
+
+// mailError is a negative IMAP or POP3 response.
+type mailError struct {
+	line string
+}
+
+func (e *mailError) Error() string {
+	return "server replied: " + e.line
+}
+
+// imapCommand sends a tagged command and reads untagged lines until its
+// tagged response, which must be OK.
+func imapCommand(c *textproto.Conn, tag, cmd string, untagged func(string)) error {
+	if err := c.PrintfLine("%s %s", tag, cmd); err != nil {
+		return err
+	}
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return err
+		}
+		rest, ok := strings.CutPrefix(line, tag+" ")
+		if !ok {
+			if untagged != nil {
+				untagged(line)
+			}
+			continue
+		}
+		if !strings.HasPrefix(rest, "OK") {
+			return &mailError{line}
+		}
+		return nil
+	}
+}
+
+func pop3Response(c *textproto.Conn) (string, error) {
+	line, err := c.ReadLine()
+	if err != nil {
+		return "", err
+	}
+	msg, ok := strings.CutPrefix(line, "+OK")
+	if !ok {
+		return "", &mailError{line}
+	}
+	return strings.TrimSpace(msg), nil
+}
+
+// capabilityNames returns the upper-cased first word of each line.
+func capabilityNames(lines []string) []string {
+	var out []string
+	for _, line := range lines {
+		if f := strings.Fields(line); len(f) > 0 {
+			out = append(out, strings.ToUpper(f[0]))
+		}
+	}
+	return out
+}
+
+type mailProber struct {
+	target   Target
+	protocol mailProtocol
+	opts     MailOptions
+	banner   *regexp.Regexp
+	addr     string
+	implicit bool
+	tls      *tls.Config
+	dial     func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+func newMailProber(t Target) (probeFunc, func()) {
+	u, _ := url.Parse(t.URL)
+	p := &mailProber{
+		target:   t,
+		protocol: mailProtocols[t.kind()],
+		addr:     hostPort(u),
+		implicit: strings.HasSuffix(u.Scheme, "s"),
+		dial:     dialContext(t),
+	}
+	if t.Mail != nil {
+		p.opts = *t.Mail
+	}
+	if p.opts.Banner != "" {
+		p.banner = regexp.MustCompile(p.opts.Banner)
+	}
+	if p.opts.Hostname == "" {
+		p.opts.Hostname = DefaultMailHostname
+	}
+	serverName := p.opts.ServerName
+	if serverName == "" {
+		serverName = u.Hostname()
+	}
+	p.tls = &tls.Config{ServerName: serverName, InsecureSkipVerify: p.opts.InsecureSkipVerify}
+	return p.probe, func() {}
+}
+
+func (p *mailProber) probe(ctx context.Context) (Result, error) {
+	res := Result{
+		Target:      p.target.Name,
+		Time:        time.Now(),
+		Status:      "success",
+		ErrorReason: FailureNone,
+	}
+	ctx, cancel := context.WithTimeout(ctx, httpClient.Timeout)
+	defer cancel()
+
+	step := time.Now()
+	timed := func(name string) {
+		now := time.Now()
+		res.Steps = append(res.Steps, Step{Name: name, Latency: now.Sub(step).Seconds()})
+		step = now
+	}
+	fail := func(status, reason string, err error) (Result, error) {
+		res.Latency = time.Since(res.Time).Seconds()
+		res.Status = status
+		res.ErrorReason = reason
+		return res, err
+	}
+	failErr := func(err error) (Result, error) {
+		var protoErr *textproto.Error
+		var mailErr *mailError
+		if errors.As(err, &protoErr) || errors.As(err, &mailErr) {
+			return fail("mail_error", FailureProtocolError, nil)
+		}
+		return fail("transport_error", classifyTransportError(err), err)
+	}
+
+	conn, err := p.dial(ctx, "tcp", p.addr)
+	if err != nil {
+		return failErr(err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if addr, err := netip.ParseAddrPort(conn.RemoteAddr().String()); err == nil {
+		res.IPVersion = addrFamily(addr.Addr())
+	}
+	timed(StepConnect)
+
+	if p.implicit {
+		tlsConn := tls.Client(conn, p.tls)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return failErr(err)
+		}
+		res.TLS = newTLSInfo(tlsConn.ConnectionState())
+		conn = tlsConn
+		timed(StepTLS)
+	}
+
+	c := textproto.NewConn(conn)
+	banner, err := p.protocol.greeting(c)
+	if err != nil {
+		return failErr(err)
+	}
+	timed(StepBanner)
+	if p.banner != nil && !p.banner.MatchString(banner) {
+		return fail("mail_error", FailureResponseMismatch, nil)
+	}
+
+	caps, err := p.protocol.capabilities(c, p.opts.Hostname)
+	if err != nil {
+		return failErr(err)
+	}
+	timed(StepCapabilities)
+
+	if p.opts.StartTLS {
+		if !slices.Contains(caps, p.protocol.startTLSCapability) {
+			return fail("mail_error", FailureStartTLSUnsupported, nil)
+		}
+		if err := p.protocol.startTLS(c); err != nil {
+			return failErr(err)
+		}
+		tlsConn := tls.Client(conn, p.tls)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return failErr(err)
+		}
+		res.TLS = newTLSInfo(tlsConn.ConnectionState())
+		c = textproto.NewConn(tlsConn)
+		timed(StepStartTLS)
+	}
+
+	res.Latency = time.Since(res.Time).Seconds()
+	p.protocol.quit(c)
+	return res, nil
+}
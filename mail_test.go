@@ -0,0 +1,312 @@
+// Copyright (c) 2025 Dakhil Y.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"maps"
+	"net"
+	"net/http/httptest"
+	"net/textproto"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeMail is a scripted mail server. It sends banner, answers each command
+// line with its reply, and switches to TLS after answering startTLS.
+// Unknown commands close the connection.
+type fakeMail struct {
+	banner   string
+	replies  map[string]string
+	startTLS string
+}
+
+// testCertificate returns the self-signed certificate httptest serves.
+func testCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(nil)
+	srv.StartTLS()
+	defer srv.Close()
+	return srv.TLS.Certificates[0]
+}
+
+// start serves f on a loopback port and returns its address.
+func (f fakeMail) start(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	cert := testCertificate(t)
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn, &tls.Config{Certificates: []tls.Certificate{cert}})
+		}
+	}()
+	return l.Addr().String()
+}
+
+func (f fakeMail) serve(conn net.Conn, config *tls.Config) {
+	defer func() { conn.Close() }()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	c := textproto.NewConn(conn)
+	if c.PrintfLine("%s", f.banner) != nil {
+		return
+	}
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return
+		}
+		reply, ok := f.replies[line]
+		if !ok || c.PrintfLine("%s", reply) != nil {
+			return
+		}
+		if line == f.startTLS {
+			tlsConn := tls.Server(conn, config)
+			if tlsConn.Handshake() != nil {
+				return
+			}
+			conn = tlsConn
+			c = textproto.NewConn(conn)
+		}
+	}
+}
+
+func smtpServer(caps ...string) fakeMail {
+	ehlo := "250-mail.example.com"
+	for _, c := range caps {
+		ehlo += "\r\n250-" + c
+	}
+	ehlo += "\r\n250 8BITMIME"
+	return fakeMail{
+		banner: "220 mail.example.com ESMTP Postfix",
+		replies: map[string]string{
+			"EHLO netpulse": ehlo,
+			"STARTTLS":      "220 2.0.0 Ready to start TLS",
+			"QUIT":          "221 2.0.0 Bye",
+		},
+		startTLS: "STARTTLS",
+	}
+}
+
+func imapServer(caps ...string) fakeMail {
+	return fakeMail{
+		banner: "* OK [CAPABILITY IMAP4rev1] Dovecot ready.",
+		replies: map[string]string{
+			"n1 CAPABILITY": "* CAPABILITY " + strings.Join(append([]string{"IMAP4rev1"}, caps...), " ") +
+				"\r\nn1 OK Capability completed.",
+			"n2 STARTTLS": "n2 OK Begin TLS negotiation now.",
+			"n3 LOGOUT":   "* BYE Logging out\r\nn3 OK Logout completed.",
+		},
+		startTLS: "n2 STARTTLS",
+	}
+}
+
+func pop3Server(caps ...string) fakeMail {
+	return fakeMail{
+		banner: "+OK Dovecot ready.",
+		replies: map[string]string{
+			"CAPA": "+OK\r\n" + strings.Join(append([]string{"TOP", "UIDL"}, caps...), "\r\n") + "\r\n.",
+			"STLS": "+OK Begin TLS negotiation now.",
+			"QUIT": "+OK Logging out.",
+		},
+		startTLS: "STLS",
+	}
+}
+
+// with returns f with the reply to line replaced.
+func (f fakeMail) with(line, reply string) fakeMail {
+	f.replies = maps.Clone(f.replies)
+	f.replies[line] = reply
+	return f
+}
+
+func TestMailProbe(t *testing.T) {
+	insecureStartTLS := &MailOptions{StartTLS: true, InsecureSkipVerify: true}
+
+	tests := []struct {
+		name       string
+		scheme     string
+		server     fakeMail
+		opts       *MailOptions
+		wantStatus string
+		wantReason string
+		wantSteps  []string
+	}{
+		{
+			name:       "smtp",
+			scheme:     "smtp",
+			server:     smtpServer(),
+			opts:       &MailOptions{Banner: "ESMTP"},
+			wantStatus: "success",
+			wantReason: FailureNone,
+			wantSteps:  []string{StepConnect, StepBanner, StepCapabilities},
+		},
+		{
+			name:       "smtp banner mismatch",
+			scheme:     "smtp",
+			server:     smtpServer(),
+			opts:       &MailOptions{Banner: "^Exim"},
+			wantStatus: "mail_error",
+			wantReason: FailureResponseMismatch,
+		},
+		{
+			name:       "smtp rejected ehlo",
+			scheme:     "smtp",
+			server:     smtpServer().with("EHLO netpulse", "554 5.7.1 Go away"),
+			wantStatus: "mail_error",
+			wantReason: FailureProtocolError,
+		},
+		{
+			name:       "smtp rejected greeting",
+			scheme:     "smtp",
+			server:     fakeMail{banner: "554 mail.example.com Service unavailable"},
+			wantStatus: "mail_error",
+			wantReason: FailureProtocolError,
+		},
+		{
+			name:       "imap rejected capability",
+			scheme:     "imap",
+			server:     imapServer().with("n1 CAPABILITY", "n1 BAD Unknown command"),
+			wantStatus: "mail_error",
+			wantReason: FailureProtocolError,
+		},
+		{
+			name:       "imap banner mismatch",
+			scheme:     "imap",
+			server:     imapServer(),
+			opts:       &MailOptions{Banner: "Cyrus"},
+			wantStatus: "mail_error",
+			wantReason: FailureResponseMismatch,
+		},
+		{
+			name:       "pop3 rejected greeting",
+			scheme:     "pop3",
+			server:     fakeMail{banner: "-ERR Too many connections"},
+			wantStatus: "mail_error",
+			wantReason: FailureProtocolError,
+		},
+		{
+			name:       "pop3 without capa",
+			scheme:     "pop3",
+			server:     pop3Server().with("CAPA", "-ERR Unknown command"),
+			wantStatus: "success",
+			wantReason: FailureNone,
+			wantSteps:  []string{StepConnect, StepBanner, StepCapabilities},
+		},
+		{
+			name:       "smtp starttls",
+			scheme:     "smtp",
+			server:     smtpServer("STARTTLS"),
+			opts:       insecureStartTLS,
+			wantStatus: "success",
+			wantReason: FailureNone,
+			wantSteps:  []string{StepConnect, StepBanner, StepCapabilities, StepStartTLS},
+		},
+		{
+			name:       "imap starttls",
+			scheme:     "imap",
+			server:     imapServer("STARTTLS"),
+			opts:       insecureStartTLS,
+			wantStatus: "success",
+			wantReason: FailureNone,
+			wantSteps:  []string{StepConnect, StepBanner, StepCapabilities, StepStartTLS},
+		},
+		{
+			name:       "pop3 stls",
+			scheme:     "pop3",
+			server:     pop3Server("STLS"),
+			opts:       insecureStartTLS,
+			wantStatus: "success",
+			wantReason: FailureNone,
+			wantSteps:  []string{StepConnect, StepBanner, StepCapabilities, StepStartTLS},
+		},
+		{
+			name:       "smtp starttls untrusted",
+			scheme:     "smtp",
+			server:     smtpServer("STARTTLS"),
+			opts:       &MailOptions{StartTLS: true},
+			wantStatus: "transport_error",
+			wantReason: FailureTLSUntrustedCA,
+		},
+		{
+			name:       "smtp starttls refused",
+			scheme:     "smtp",
+			server:     smtpServer("STARTTLS").with("STARTTLS", "454 4.7.0 TLS not available"),
+			opts:       insecureStartTLS,
+			wantStatus: "mail_error",
+			wantReason: FailureProtocolError,
+		},
+		{
+			name:       "smtp starttls unsupported",
+			scheme:     "smtp",
+			server:     smtpServer(),
+			opts:       insecureStartTLS,
+			wantStatus: "mail_error",
+			wantReason: FailureStartTLSUnsupported,
+		},
+		{
+			name:       "imap starttls unsupported",
+			scheme:     "imap",
+			server:     imapServer(),
+			opts:       insecureStartTLS,
+			wantStatus: "mail_error",
+			wantReason: FailureStartTLSUnsupported,
+		},
+		{
+			name:       "pop3 stls unsupported",
+			scheme:     "pop3",
+			server:     pop3Server(),
+			opts:       insecureStartTLS,
+			wantStatus: "mail_error",
+			wantReason: FailureStartTLSUnsupported,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := Target{
+				URL:      tt.scheme + "://" + tt.server.start(t),
+				Interval: Duration(time.Second),
+				Mail:     tt.opts,
+			}
+			target.Name = target.URL
+			if err := target.validate(); err != nil {
+				t.Fatal(err)
+			}
+			run, closeProber := newMailProber(target)
+			defer closeProber()
+
+			res, err := run(context.Background())
+			if res.Status != tt.wantStatus || res.ErrorReason != tt.wantReason {
+				t.Fatalf("result = %s/%s (%v), want %s/%s", res.Status, res.ErrorReason, err,
+					tt.wantStatus, tt.wantReason)
+			}
+			if tt.wantStatus == "mail_error" && err != nil {
+				t.Errorf("error = %v, want nil for a mail_error", err)
+			}
+			if tt.wantSteps == nil {
+				return
+			}
+			var steps []string
+			for _, s := range res.Steps {
+				steps = append(steps, s.Name)
+			}
+			if !slices.Equal(steps, tt.wantSteps) {
+				t.Errorf("steps = %v, want %v", steps, tt.wantSteps)
+			}
+			if slices.Contains(tt.wantSteps, StepStartTLS) && res.TLS == nil {
+				t.Error("no TLS info after STARTTLS")
+			}
+		})
+	}
+}
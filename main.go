@@ -8,17 +8,16 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"net/http/httptrace"
+	"os"
 	"strings"
 	"sync/atomic"
 	"time"
-
-	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 const (
-	GlobalSlotSize = 10
+	GlobalSlotSize   = 10
+	ResultBufferSize = 1000
 
 	//Failures
 	FailureNone = "none"
@@ -41,42 +40,52 @@ const (
 	FailureHTTP4xx = "http_4xx"
 	FailureHTTP5xx = "http_5xx"
 
-	FailureUnknown = "unknown"
-)
+	FailureGRPCNotServing  = "grpc_not_serving"
+	FailureGRPCUnavailable = "grpc_unavailable"
+	FailureGRPCClientError = "grpc_client_error"
+	FailureGRPCServerError = "grpc_server_error"
 
-var globalSem = make(chan struct{}, GlobalSlotSize)
+	FailureWebSocketUpgrade = "websocket_upgrade_failed"
+	FailureWebSocketClosed  = "websocket_closed"
 
-var pingLatency = promauto.NewHistogramVec(
-	prometheus.HistogramOpts{
-		Namespace: "netpulse",
-		Name:      "latency_seconds",
-		Buckets: []float64{
-			0.01, 0.05, 0.1, 0.2, 0.3, 0.4, 0.5, 1.0, 2.5, 5.0,
-		},
-	},
-	[]string{"target", "status", "error_reason"},
-)
+	// FailureResponseMismatch is a reply that does not match the expected
+	// pattern.
+	FailureResponseMismatch = "response_mismatch"
 
-var pingCount = promauto.NewCounterVec(prometheus.CounterOpts{
-	Name: "netpulse_requests_total",
-	Help: "Total number of pings sent",
-}, []string{"target"})
-
-var probeErrorsTotal = promauto.NewCounterVec(
-	prometheus.CounterOpts{
-		Name: "probe_errors_total",
-		Help: "Total number of probe errors by error reason",
-	},
-	[]string{"error_reason"},
-)
+	// FailureProtocolError is a negative reply of a non-HTTP protocol.
+	FailureProtocolError       = "protocol_error"
+	FailureStartTLSUnsupported = "starttls_unsupported"
+
+	FailureAuthFailed  = "auth_failed"
+	FailureQueryFailed = "query_failed"
+	// FailureCredentials is a password file or variable that cannot be read.
+	FailureCredentials = "credentials_unavailable"
+
+	// FailureDestinationUnreachable is a path probe that did not reach its
+	// destination.
+	FailureDestinationUnreachable = "destination_unreachable"
+	FailureMTUBelowThreshold      = "mtu_below_threshold"
 
-var inFlightGauge = promauto.NewGauge(
-	prometheus.GaugeOpts{
-		Name: "in_flight_gauge",
-		Help: "Gauge of currently running probes",
-	},
+	// FailureUnexpectedStatus is a status a script step does not expect,
+	// and FailureExtractFailed a variable missing from its response.
+	FailureUnexpectedStatus = "unexpected_status"
+	FailureExtractFailed    = "extract_failed"
+
+	// FailureProtocolNotNegotiated is a server that does not speak the HTTP
+	// protocol forced for the target.
+	FailureProtocolNotNegotiated = "protocol_not_negotiated"
+
+	// FailureBodyRead is a response body that could not be read or decoded,
+	// and FailureContentLengthMismatch one that ended before or after its
+	// Content-Length.
+	FailureBodyRead              = "body_read_error"
+	FailureContentLengthMismatch = "content_length_mismatch"
+
+	FailureUnknown = "unknown"
 )
 
+var globalSem = make(chan struct{}, GlobalSlotSize)
+
 func classifyTransportError(err error) string {
 	var dnsErr *net.DNSError
 	var netErr net.Error
@@ -141,51 +150,199 @@ var httpClient = &http.Client{
 	Timeout: 5 * time.Second,
 }
 
-func probe(target string) {
+// newHTTPClient returns the client used to probe t. Targets pinned to an
+// address or address family get their own transport, so that pooled
+// connections to one address are never reused for another.
+func newHTTPClient(t Target) *http.Client {
+	protocol := t.httpProtocol()
+	if t.Address == "" && dialNetwork("tcp", t.IPVersion) == "tcp" && protocol == "" &&
+		t.httpConnection() == ConnectionReuse {
+		return httpClient
+	}
+	if protocol == ProtocolH3 {
+		return &http.Client{
+			Timeout:   httpClient.Timeout,
+			Transport: newHTTP3Transport(t),
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialContext(t)
+	setProtocols(transport, protocol)
+	transport.DisableKeepAlives = t.httpConnection() == ConnectionNew
+
+	return &http.Client{
+		Timeout:   httpClient.Timeout,
+		Transport: transport,
+	}
+}
+
+// dialContext returns a dial function connecting to t's pinned address, or
+// to the requested address otherwise, over t's address family.
+func dialContext(t Target) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: httpClient.Timeout, KeepAlive: 30 * time.Second}
+	network := dialNetwork("tcp", t.IPVersion)
+
+	return func(ctx context.Context, _, addr string) (net.Conn, error) {
+		if t.Address != "" {
+			addr = t.Address
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+}
+
+// Target is a single endpoint probed on its own schedule. Address pins the
+// connection to one host:port; FanOut instead probes every address the URL
+// host resolves to as a separate target. IPVersion restricts connections to
+// one address family, or probes each family separately.
+type Target struct {
+	Name      string            `yaml:"name" json:"name"`
+	URL       string            `yaml:"url" json:"url"`
+	Address   string            `yaml:"address" json:"address,omitempty"`
+	FanOut    bool              `yaml:"fan_out" json:"fan_out,omitempty"`
+	IPVersion string            `yaml:"ip_version" json:"ip_version,omitempty"`
+	Interval  Duration          `yaml:"interval" json:"interval"`
+	Labels    map[string]string `yaml:"labels" json:"labels,omitempty"`
+	Paused    bool              `yaml:"paused" json:"paused"`
+
+	GRPC       *GRPCOptions       `yaml:"grpc" json:"grpc,omitempty"`
+	WebSocket  *WebSocketOptions  `yaml:"websocket" json:"websocket,omitempty"`
+	UDP        *UDPOptions        `yaml:"udp" json:"udp,omitempty"`
+	Mail       *MailOptions       `yaml:"mail" json:"mail,omitempty"`
+	Database   *DatabaseOptions   `yaml:"database" json:"database,omitempty"`
+	Path       *PathOptions       `yaml:"path" json:"path,omitempty"`
+	MTU        *MTUOptions        `yaml:"mtu" json:"mtu,omitempty"`
+	HTTP       *HTTPOptions       `yaml:"http" json:"http,omitempty"`
+	Script     *ScriptOptions     `yaml:"script" json:"script,omitempty"`
+	Throughput *ThroughputOptions `yaml:"throughput" json:"throughput,omitempty"`
+}
+
+// probe runs one probe against target, records its metrics and keeps the
+// result for the query API. ctx is cancelled when the target is paused,
+// changed or removed; a probe cut short by that is not recorded.
+func probe(ctx context.Context, t Target, run probeFunc) {
 	inFlightGauge.Inc()
 	defer inFlightGauge.Dec()
 
-	pingCount.WithLabelValues(target).Inc()
-	start := time.Now()
+	res, err := run(ctx)
+	if !observe(ctx, t, res) {
+		return
+	}
 
-	resp, err := httpClient.Get(target)
-	duration := time.Since(start).Seconds()
+	if err != nil {
+		fmt.Printf("Transport error probing %s: %v\n", t.Name, err)
+		return
+	}
+
+	fmt.Printf("Target: %s | Status: %s | Code: %d | Latency: %.3fs\n",
+		t.Name, res.Status, res.Code, res.Latency)
+}
+
+// probeHTTP fetches the target URL over a new connection, an idle one if
+// there is, or first one and then the other, as t asks for. When measuring
+// both the result is that of the new connection, with the latency of the
+// reused one added, unless the second request fails. A second request that
+// could not reuse the connection, because the server closed it or the first
+// body was not read to the end, adds no latency.
+func probeHTTP(ctx context.Context, t Target, client *http.Client) (Result, error) {
+	mode := t.httpConnection()
+	// HTTP/3 has no keep-alive to disable
+	if mode == ConnectionBoth || (mode == ConnectionNew && t.httpProtocol() == ProtocolH3) {
+		client.CloseIdleConnections()
+	}
+	res, err := requestHTTP(ctx, t, client)
+	if mode != ConnectionBoth || res.Status != "success" {
+		return res, err
+	}
 
-	status := "success"
-	errorReason := FailureNone
+	warm, err := requestHTTP(ctx, t, client)
+	if warm.Status != "success" {
+		res.Status, res.ErrorReason, res.Code = warm.Status, warm.ErrorReason, warm.Code
+		return res, err
+	}
+	if warm.Connection == ConnectionReused {
+		res.ReusedLatency = warm.Latency
+	}
+	return res, nil
+}
+
+// requestHTTP performs a single GET against the target URL and classifies the outcome.
+// The transport error, if any, is returned alongside the result for logging.
+func requestHTTP(ctx context.Context, t Target, client *http.Client) (Result, error) {
+	var tracer phaseTracer
+	ctx = httptrace.WithClientTrace(ctx, tracer.trace())
+
+	res := Result{
+		Target:      t.Name,
+		Time:        time.Now(),
+		Status:      "success",
+		ErrorReason: FailureNone,
+	}
 
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.URL, nil)
 	if err != nil {
-		status = "transport_error"
-		errorReason = classifyTransportError(err)
+		res.Status = "transport_error"
+		res.ErrorReason = FailureUnknown
+		return res, err
+	}
+	req.Header.Set("Accept-Encoding", acceptEncoding)
 
-		probeErrorsTotal.WithLabelValues(errorReason).Inc()
-		pingLatency.WithLabelValues(target, status, errorReason).Observe(duration)
+	resp, err := client.Do(req)
+	res.Latency = time.Since(res.Time).Seconds()
+	res.Phases = tracer.phases(res.Time)
+	res.IPVersion, res.Fallback = tracer.family()
+	res.Connection = tracer.connection()
 
-		fmt.Printf("Transport error probing %s: %v\n", target, err)
-		return
+	if err != nil {
+		res.Status = "transport_error"
+		res.ErrorReason = classifyHTTPError(t, err)
+		return res, err
 	}
 
 	defer resp.Body.Close()
 
-	if resp.StatusCode >= 400 {
-		status = "http_error"
-		errorReason = classifyHTTPStatus(resp.StatusCode)
-		probeErrorsTotal.WithLabelValues(errorReason).Inc()
-	}
+	res.Code = resp.StatusCode
+	res.Protocol = responseProtocol(resp)
 
-	pingLatency.WithLabelValues(target, status, errorReason).Observe(duration)
+	headers := time.Now()
+	res.Body, err = readBody(resp, t.maxBodySize())
+	res.Phases.Transfer = time.Since(headers).Seconds()
+	res.Latency = time.Since(res.Time).Seconds()
+	if res.Phases.Transfer > 0 {
+		res.Body.Throughput = float64(res.Body.Bytes) / res.Phases.Transfer
+	}
 
-	fmt.Printf("Target: %s | Status: %s | Code: %d | Latency: %.3fs\n",
-		target, status, resp.StatusCode, duration)
+	switch {
+	case err != nil:
+		res.Status = "transport_error"
+		res.ErrorReason = classifyBodyError(err)
+		return res, err
+	case !protocolNegotiated(t, resp):
+		res.Status = "http_error"
+		res.ErrorReason = FailureProtocolNotNegotiated
+	case resp.StatusCode >= 400:
+		res.Status = "http_error"
+		res.ErrorReason = classifyHTTPStatus(resp.StatusCode)
+	}
+	return res, nil
 }
 
-func startIndividualProber(target string, interval time.Duration) {
-	ticker := time.NewTicker(interval)
+func startIndividualProber(ctx context.Context, target Target) {
+	ticker := time.NewTicker(time.Duration(target.Interval))
 	defer ticker.Stop()
 
+	run, closeProber := newProber(target)
+	defer closeProber()
+
 	var running atomic.Bool
 
-	for range ticker.C {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
 		if !running.CompareAndSwap(false, true) {
 			continue
 		}
@@ -200,32 +357,11 @@ func startIndividualProber(target string, interval time.Duration) {
 		go func() {
 			defer running.Store(false)
 			defer func() { <-globalSem }()
-			probe(target)
+			probe(ctx, target, run)
 		}()
 	}
 }
 
 func main() {
-	targets := []string{
-		"https://www.google.com",
-		"https://www.facebook.com",
-		"https://www.github.com",
-		"https://www.giub.com/",
-		"https://localhost:8080",
-		"https://tools-httpstatus.pickup-services.com/404",
-		"https://tools-httpstatus.pickup-services.com/503",
-		"https://tools-httpstatus.pickup-services.com/200?sleep=5000",
-	}
-
-	go func() {
-		http.Handle("/metrics", promhttp.Handler())
-		http.ListenAndServe(":8080", nil)
-	}()
-
-	for _, t := range targets {
-		go startIndividualProber(t, 500*time.Millisecond)
-	}
-
-	select {}
-
+	os.Exit(run(os.Args[1:]))
 }
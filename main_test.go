@@ -0,0 +1,106 @@
+// Copyright (c) 2025 Dakhil Y.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+func TestProbeHTTPConnectionBoth(t *testing.T) {
+	tests := []struct {
+		name       string
+		close      bool
+		wantReused bool
+	}{
+		{name: "keep-alive", wantReused: true},
+		// the second request has to open a new connection
+		{name: "connection close", close: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.close {
+					w.Header().Set("Connection", "close")
+				}
+				w.Write([]byte("ok"))
+			}))
+			defer srv.Close()
+
+			target := Target{
+				URL:      srv.URL,
+				Interval: Duration(time.Second),
+				HTTP:     &HTTPOptions{Connection: ConnectionBoth},
+			}
+			target.Name = target.URL
+			if err := target.validate(); err != nil {
+				t.Fatal(err)
+			}
+			run, closeProber := newProber(target)
+			defer closeProber()
+
+			res, err := run(context.Background())
+			if err != nil || !res.Success() {
+				t.Fatalf("result = %s/%s, %v", res.Status, res.ErrorReason, err)
+			}
+			if res.Connection != ConnectionNew {
+				t.Errorf("connection = %q, want %q", res.Connection, ConnectionNew)
+			}
+			if got := res.ReusedLatency > 0; got != tt.wantReused {
+				t.Errorf("reused latency = %v, want it set %v", res.ReusedLatency, tt.wantReused)
+			}
+		})
+	}
+}
+
+func TestProbeHTTP3Connection(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http3.Server{
+		TLSConfig: http3.ConfigureTLSConfig(&tls.Config{Certificates: []tls.Certificate{testCertificate(t)}}),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok"))
+		}),
+	}
+	go srv.Serve(pc)
+	defer srv.Close()
+
+	target := Target{
+		URL:      "https://" + pc.LocalAddr().String() + "/",
+		Interval: Duration(time.Second),
+		HTTP:     &HTTPOptions{Protocol: ProtocolH3, Connection: ConnectionBoth},
+	}
+	target.Name = target.URL
+	if err := target.validate(); err != nil {
+		t.Fatal(err)
+	}
+	client := newHTTPClient(target)
+	defer client.CloseIdleConnections()
+	client.Transport.(*http3.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+
+	for _, want := range []string{ConnectionNew, ConnectionReused} {
+		res, err := requestHTTP(context.Background(), target, client)
+		if err != nil || !res.Success() {
+			t.Fatalf("result = %s/%s, %v", res.Status, res.ErrorReason, err)
+		}
+		if res.Protocol != ProtocolH3 || res.Connection != want {
+			t.Errorf("protocol, connection = %s, %s, want %s, %s", res.Protocol, res.Connection, ProtocolH3, want)
+		}
+	}
+
+	res, err := probeHTTP(context.Background(), target, client)
+	if err != nil || !res.Success() {
+		t.Fatalf("result = %s/%s, %v", res.Status, res.ErrorReason, err)
+	}
+	if res.Connection != ConnectionNew || res.ReusedLatency <= 0 {
+		t.Errorf("connection = %s, reused latency = %v, want new and set", res.Connection, res.ReusedLatency)
+	}
+}
@@ -0,0 +1,431 @@
+// Copyright (c) 2025 Dakhil Y.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	OverflowRefuse    = "refuse"
+	OverflowAggregate = "aggregate"
+
+	// OverflowTarget is the target label of series aggregated by the
+	// cardinality limiter.
+	OverflowTarget = "_overflow"
+)
+
+// targetLabels are the custom label names carried by every per-target
+// metric, right after "target". They are fixed by initMetrics at startup.
+var targetLabels []string
+
+var limiter = newCardinalityLimiter(CardinalityConfig{Overflow: OverflowRefuse})
+
+var (
+	pingLatency      *prometheus.HistogramVec
+	pingCount        *prometheus.CounterVec
+	probeErrorsTotal *prometheus.CounterVec
+	connectionsTotal *prometheus.CounterVec
+	stepLatency      *prometheus.HistogramVec
+	attemptsTotal    *prometheus.CounterVec
+	lostTotal        *prometheus.CounterVec
+	tlsCertExpiry    *prometheus.GaugeVec
+	pathHops         *prometheus.GaugeVec
+	pathChanges      *prometheus.CounterVec
+	hopLatency       *prometheus.GaugeVec
+	hopLoss          *prometheus.GaugeVec
+	pathMTU          *prometheus.GaugeVec
+	httpResponses    *prometheus.CounterVec
+	httpLatency      *prometheus.HistogramVec
+	bodyBytes        *prometheus.GaugeVec
+	bodyDecompressed *prometheus.GaugeVec
+	bodyThroughput   *prometheus.GaugeVec
+	throughputBits   *prometheus.GaugeVec
+	throughputTime   *prometheus.GaugeVec
+)
+
+var inFlightGauge = promauto.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "in_flight_gauge",
+		Help: "Gauge of currently running probes",
+	},
+)
+
+var seriesOverflowTotal = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "netpulse_cardinality_overflow_total",
+		Help: "Probe observations refused or aggregated because the target limit was reached",
+	},
+	[]string{"action"},
+)
+
+var seriesTargets = promauto.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "netpulse_cardinality_targets",
+		Help: "Number of targets currently exported with their own series",
+	},
+)
+
+// initMetrics registers the per-target metrics with the given custom label
+// names. It must run before any target is probed.
+func initMetrics(labels []string, cardinality CardinalityConfig) {
+	targetLabels = labels
+	limiter = newCardinalityLimiter(cardinality)
+
+	pingLatency = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "netpulse",
+			Name:      "latency_seconds",
+			Buckets: []float64{
+				0.01, 0.05, 0.1, 0.2, 0.3, 0.4, 0.5, 1.0, 2.5, 5.0,
+			},
+		},
+		withTargetLabels("status", "error_reason"),
+	)
+
+	pingCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "netpulse_requests_total",
+		Help: "Total number of pings sent",
+	}, withTargetLabels())
+
+	probeErrorsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "probe_errors_total",
+			Help: "Total number of probe errors by error reason",
+		},
+		withTargetLabels("error_reason"),
+	)
+
+	connectionsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "netpulse_connections_total",
+			Help: "New connections by address family and whether Happy Eyeballs fell back to it",
+		},
+		withTargetLabels("family", "fallback"),
+	)
+
+	stepLatency = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "netpulse",
+			Name:      "step_latency_seconds",
+			Help:      "Latency of the individual steps of multi-step probes",
+			Buckets: []float64{
+				0.01, 0.05, 0.1, 0.2, 0.3, 0.4, 0.5, 1.0, 2.5, 5.0,
+			},
+		},
+		withTargetLabels("step"),
+	)
+
+	attemptsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "netpulse_attempts_total",
+		Help: "Requests sent by probes that send several per probe",
+	}, withTargetLabels())
+
+	lostTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "netpulse_lost_total",
+		Help: "Requests of multi-attempt probes that got no matching reply",
+	}, withTargetLabels())
+
+	tlsCertExpiry = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "netpulse_tls_cert_expiry_timestamp_seconds",
+		Help: "Expiry of the server certificate seen by the last TLS handshake, in unix seconds",
+	}, withTargetLabels())
+
+	pathHops = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "netpulse_path_hops",
+		Help: "Number of hops of the last path probe",
+	}, withTargetLabels())
+
+	pathChanges = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "netpulse_path_changes_total",
+		Help: "Path probes whose hops differed from the previous probe",
+	}, withTargetLabels())
+
+	hopLatency = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "netpulse_path_hop_latency_seconds",
+		Help: "Mean round trip time to each hop of the last path probe",
+	}, withTargetLabels("hop", "address"))
+
+	hopLoss = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "netpulse_path_hop_loss_ratio",
+		Help: "Share of packets to each hop of the last path probe that got no answer",
+	}, withTargetLabels("hop", "address"))
+
+	pathMTU = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "netpulse_path_mtu_bytes",
+		Help: "Path MTU discovered by the last mtu probe",
+	}, withTargetLabels())
+
+	httpResponses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "netpulse_http_responses_total",
+		Help: "HTTP responses by the protocol they arrived over",
+	}, withTargetLabels("protocol"))
+
+	httpLatency = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "netpulse",
+			Name:      "http_request_latency_seconds",
+			Help:      "Latency of HTTP requests by whether they opened a new connection or reused one",
+			Buckets: []float64{
+				0.01, 0.05, 0.1, 0.2, 0.3, 0.4, 0.5, 1.0, 2.5, 5.0,
+			},
+		},
+		withTargetLabels("connection"),
+	)
+
+	bodyBytes = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "netpulse_http_body_bytes",
+		Help: "Size of the last response body as received, by content encoding",
+	}, withTargetLabels("encoding"))
+
+	bodyDecompressed = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "netpulse_http_body_decompressed_bytes",
+		Help: "Size of the last response body after undoing its content encoding",
+	}, withTargetLabels())
+
+	bodyThroughput = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "netpulse_http_body_throughput_bytes_per_second",
+		Help: "Rate the last response body arrived at after its headers",
+	}, withTargetLabels())
+
+	throughputBits = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "netpulse_throughput_bits_per_second",
+		Help: "Throughput achieved by the last successful throughput probe",
+	}, withTargetLabels())
+
+	throughputTime = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "netpulse_throughput_transfer_seconds",
+		Help: "Time the payload of the last successful throughput probe took",
+	}, withTargetLabels())
+}
+
+// observe records a probe result in the standard per-target metrics and the
+// result store. It reports false, recording nothing, when the target was
+// stopped or restarted since the probe started: the check is made under the
+// scheduler lock, so a late result cannot bring back series or a limiter
+// slot that stop has released.
+func observe(ctx context.Context, t Target, res Result) bool {
+	targets.mu.Lock()
+	defer targets.mu.Unlock()
+	if _, ok := targets.jobs[t.Name]; !ok || ctx.Err() != nil {
+		return false
+	}
+
+	results.record(res)
+
+	lv, ok := metricLabels(t)
+	if !ok {
+		return true
+	}
+
+	pingCount.WithLabelValues(lv.with()...).Inc()
+	if res.ErrorReason != FailureNone {
+		probeErrorsTotal.WithLabelValues(lv.with(res.ErrorReason)...).Inc()
+	}
+	pingLatency.WithLabelValues(lv.with(res.Status, res.ErrorReason)...).Observe(res.Latency)
+	if res.IPVersion != "" {
+		connectionsTotal.WithLabelValues(lv.with(res.IPVersion, strconv.FormatBool(res.Fallback))...).Inc()
+	}
+	for _, s := range res.Steps {
+		stepLatency.WithLabelValues(lv.with(s.Name)...).Observe(s.Latency)
+	}
+	if res.Attempts > 0 {
+		attemptsTotal.WithLabelValues(lv.with()...).Add(float64(res.Attempts))
+		lostTotal.WithLabelValues(lv.with()...).Add(float64(res.Lost))
+	}
+	if res.TLS != nil && !res.TLS.NotAfter.IsZero() {
+		tlsCertExpiry.WithLabelValues(lv.with()...).Set(float64(res.TLS.NotAfter.Unix()))
+	}
+	if res.Hops != nil {
+		observeHops(lv, res)
+	}
+	if res.MTU > 0 {
+		pathMTU.WithLabelValues(lv.with()...).Set(float64(res.MTU))
+	}
+	if res.Protocol != "" {
+		httpResponses.WithLabelValues(lv.with(res.Protocol)...).Inc()
+	}
+	if res.Connection != "" {
+		httpLatency.WithLabelValues(lv.with(res.Connection)...).Observe(res.Latency)
+	}
+	if res.ReusedLatency > 0 {
+		httpLatency.WithLabelValues(lv.with(ConnectionReused)...).Observe(res.ReusedLatency)
+	}
+	if res.Body != nil {
+		observeBody(lv, res.Body)
+	}
+	if tr := res.Transfer; tr != nil && tr.Seconds > 0 {
+		throughputBits.WithLabelValues(lv.with()...).Set(tr.BitsPerSecond)
+		throughputTime.WithLabelValues(lv.with()...).Set(tr.Seconds)
+	}
+	return true
+}
+
+// observeHops replaces the hop series of a target with those of the last
+// path probe.
+func observeHops(lv labelValues, res Result) {
+	pathHops.WithLabelValues(lv.with()...).Set(float64(len(res.Hops)))
+	if res.PathChanged {
+		pathChanges.WithLabelValues(lv.with()...).Inc()
+	}
+
+	// hops and their addresses change along with the path
+	match := prometheus.Labels{"target": lv[0]}
+	hopLatency.DeletePartialMatch(match)
+	hopLoss.DeletePartialMatch(match)
+	for _, h := range res.Hops {
+		values := lv.with(strconv.Itoa(h.TTL), h.Address)
+		if h.Sent > h.Lost {
+			hopLatency.WithLabelValues(values...).Set(h.Latency)
+		}
+		hopLoss.WithLabelValues(values...).Set(float64(h.Lost) / float64(h.Sent))
+	}
+}
+
+// observeBody replaces the body series of a target with those of the last
+// response, dropping the series of an encoding it no longer uses.
+func observeBody(lv labelValues, b *Body) {
+	bodyBytes.DeletePartialMatch(prometheus.Labels{"target": lv[0]})
+	bodyBytes.WithLabelValues(lv.with(b.Encoding)...).Set(float64(b.Bytes))
+	if b.DecompressedBytes > 0 {
+		bodyDecompressed.WithLabelValues(lv.with()...).Set(float64(b.DecompressedBytes))
+	}
+	if b.Throughput > 0 {
+		bodyThroughput.WithLabelValues(lv.with()...).Set(b.Throughput)
+	}
+}
+
+// withTargetLabels returns the label names of a per-target metric: target,
+// the custom labels, then extra.
+func withTargetLabels(extra ...string) []string {
+	names := make([]string, 0, 1+len(targetLabels)+len(extra))
+	names = append(names, "target")
+	names = append(names, targetLabels...)
+	return append(names, extra...)
+}
+
+// labelValues are the values of the target and custom labels of one target.
+type labelValues []string
+
+// with returns the values matching withTargetLabels(extra...).
+func (lv labelValues) with(extra ...string) []string {
+	values := make([]string, 0, len(lv)+len(extra))
+	values = append(values, lv...)
+	return append(values, extra...)
+}
+
+// metricLabels returns the label values a target is exported with, after
+// the cardinality limit. ok is false when its series are refused. Custom
+// labels the target does not set are exported empty.
+func metricLabels(t Target) (lv labelValues, ok bool) {
+	name, ok := limiter.admit(t.Name)
+	if !ok {
+		return nil, false
+	}
+
+	lv = make(labelValues, 0, 1+len(targetLabels))
+	lv = append(lv, name)
+	for _, label := range targetLabels {
+		if name == OverflowTarget {
+			lv = append(lv, "")
+			continue
+		}
+		lv = append(lv, t.Labels[label])
+	}
+	return lv, true
+}
+
+// deleteSeries removes every series of a target, so that removed targets and
+// replaced label values stop being exported.
+func deleteSeries(name string) {
+	if pingLatency == nil {
+		return
+	}
+	match := prometheus.Labels{"target": name}
+	pingLatency.DeletePartialMatch(match)
+	pingCount.DeletePartialMatch(match)
+	probeErrorsTotal.DeletePartialMatch(match)
+	connectionsTotal.DeletePartialMatch(match)
+	stepLatency.DeletePartialMatch(match)
+	attemptsTotal.DeletePartialMatch(match)
+	lostTotal.DeletePartialMatch(match)
+	tlsCertExpiry.DeletePartialMatch(match)
+	pathHops.DeletePartialMatch(match)
+	pathChanges.DeletePartialMatch(match)
+	hopLatency.DeletePartialMatch(match)
+	hopLoss.DeletePartialMatch(match)
+	pathMTU.DeletePartialMatch(match)
+	httpResponses.DeletePartialMatch(match)
+	httpLatency.DeletePartialMatch(match)
+	bodyBytes.DeletePartialMatch(match)
+	bodyDecompressed.DeletePartialMatch(match)
+	bodyThroughput.DeletePartialMatch(match)
+	throughputBits.DeletePartialMatch(match)
+	throughputTime.DeletePartialMatch(match)
+}
+
+// CardinalityConfig caps the number of targets exported with their own
+// series. Zero MaxTargets means no limit.
+type CardinalityConfig struct {
+	MaxTargets int    `yaml:"max_targets"`
+	Overflow   string `yaml:"overflow"`
+}
+
+func (c CardinalityConfig) validate() error {
+	if c.MaxTargets < 0 {
+		return errors.New("max_targets must not be negative")
+	}
+	if c.Overflow != OverflowRefuse && c.Overflow != OverflowAggregate {
+		return fmt.Errorf("overflow must be %q or %q", OverflowRefuse, OverflowAggregate)
+	}
+	return nil
+}
+
+// cardinalityLimiter admits targets into the metrics on a first come basis
+// until the cap is reached. Targets beyond it are dropped or folded into
+// the OverflowTarget series.
+type cardinalityLimiter struct {
+	cfg CardinalityConfig
+
+	mu       sync.Mutex
+	admitted map[string]bool
+}
+
+func newCardinalityLimiter(cfg CardinalityConfig) *cardinalityLimiter {
+	return &cardinalityLimiter{cfg: cfg, admitted: make(map[string]bool)}
+}
+
+// admit returns the target label to export name under.
+func (l *cardinalityLimiter) admit(name string) (string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.admitted[name] {
+		return name, true
+	}
+	if l.cfg.MaxTargets == 0 || len(l.admitted) < l.cfg.MaxTargets {
+		l.admitted[name] = true
+		seriesTargets.Set(float64(len(l.admitted)))
+		return name, true
+	}
+
+	seriesOverflowTotal.WithLabelValues(l.cfg.Overflow).Inc()
+	if l.cfg.Overflow == OverflowAggregate {
+		return OverflowTarget, true
+	}
+	return "", false
+}
+
+func (l *cardinalityLimiter) release(name string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.admitted, name)
+	seriesTargets.Set(float64(len(l.admitted)))
+}
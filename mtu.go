@@ -0,0 +1,320 @@
+// Copyright (c) 2025 Dakhil Y.
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"net/url"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	DefaultMTUMax      = 1500
+	DefaultMTUAttempts = 2
+	MaxMTUAttempts     = 10
+	DefaultMTUTimeout  = time.Second
+	// DefaultMTUInterval spaces out mtu probes: path MTUs rarely change and
+	// each probe sends a burst of large packets.
+	DefaultMTUInterval = 5 * time.Minute
+
+	// Smallest packets every IPv4 and IPv6 link must carry.
+	MinIPv4MTU = 68
+	MinIPv6MTU = 1280
+)
+
+// MTUOptions configures an mtu://host target. Each probe searches for the
+// largest ICMP echo request between the minimum MTU of the address family
+// and Max that reaches the host with the don't-fragment bit set. A size is
+// too large when a router reports it, or when none of Attempts requests is
+// answered within Timeout, as with a blackhole. The probe fails when the
+// discovered MTU is below Threshold.
+type MTUOptions struct {
+	Max       int      `yaml:"max" json:"max,omitempty"`
+	Threshold int      `yaml:"threshold" json:"threshold,omitempty"`
+	Attempts  int      `yaml:"attempts" json:"attempts,omitempty"`
+	Timeout   Duration `yaml:"timeout" json:"timeout,omitempty"`
+}
+
+func (o *MTUOptions) validate(t *Target) error {
+	u, err := url.Parse(t.URL)
+	if err != nil {
+		return err
+	}
+	if u.Port() != "" || (u.Path != "" && u.Path != "/") {
+		return fmt.Errorf("invalid url %q: mtu url must only name a host", t.URL)
+	}
+	if o == nil {
+		return nil
+	}
+	if o.Max != 0 && (o.Max < MinIPv4MTU || o.Max > 65535) {
+		return fmt.Errorf("mtu.max must be between %d and 65535", MinIPv4MTU)
+	}
+	limit := o.Max
+	if limit == 0 {
+		limit = DefaultMTUMax
+	}
+	if o.Threshold < 0 || o.Threshold > limit {
+		return errors.New("mtu.threshold must not be above mtu.max")
+	}
+	if o.Attempts < 0 || o.Attempts > MaxMTUAttempts {
+		return fmt.Errorf("mtu.attempts must be between 1 and %d", MaxMTUAttempts)
+	}
+	if o.Timeout < 0 {
+		return errors.New("mtu.timeout must not be negative")
+	}
+	return nil
+}
+
+type mtuProber struct {
+	target    Target
+	host      string
+	max       int
+	threshold int
+	attempts  int
+	timeout   time.Duration
+	id        int
+	seq       int
+}
+
+func newMTUProber(t Target) (probeFunc, func()) {
+	var opts MTUOptions
+	if t.MTU != nil {
+		opts = *t.MTU
+	}
+
+	u, _ := url.Parse(t.URL)
+	p := &mtuProber{
+		target:    t,
+		host:      u.Hostname(),
+		max:       opts.Max,
+		threshold: opts.Threshold,
+		attempts:  opts.Attempts,
+		timeout:   time.Duration(opts.Timeout),
+		id:        rand.IntN(1 << 16),
+	}
+	if t.Address != "" {
+		p.host, _, _ = net.SplitHostPort(t.Address)
+	}
+	if p.max == 0 {
+		p.max = DefaultMTUMax
+	}
+	if p.attempts == 0 {
+		p.attempts = DefaultMTUAttempts
+	}
+	if p.timeout == 0 {
+		p.timeout = DefaultMTUTimeout
+	}
+	return p.probe, func() {}
+}
+
+// errTooBig is a packet a router or the local interface refused to forward
+// without fragmenting it.
+type errTooBig struct {
+	// mtu is the next-hop MTU reported, or 0
+	mtu int
+}
+
+func (e *errTooBig) Error() string {
+	return fmt.Sprintf("packet too big, next-hop MTU %d", e.mtu)
+}
+
+var errNoReply = errors.New("no reply")
+
+// probe reports the discovered MTU, and the round trip time of the largest
+// echo request that got through as its latency.
+func (p *mtuProber) probe(ctx context.Context) (Result, error) {
+	res := Result{
+		Target:      p.target.Name,
+		Time:        time.Now(),
+		Status:      "success",
+		ErrorReason: FailureNone,
+	}
+	fail := func(err error) (Result, error) {
+		res.Latency = time.Since(res.Time).Seconds()
+		res.Status = "transport_error"
+		res.ErrorReason = classifyTransportError(err)
+		return res, err
+	}
+
+	dst, err := resolveHost(ctx, p.host, p.target.IPVersion)
+	if err != nil {
+		return fail(err)
+	}
+	res.IPVersion = addrFamily(dst)
+
+	conn, err := listenDontFragment(ctx, dst)
+	if err != nil {
+		return fail(err)
+	}
+	defer conn.Close()
+
+	// fits reports whether a packet of size bytes reaches dst, lowering hi
+	// below size, or to the MTU a router reports
+	lo, hi := MinIPv4MTU, p.max
+	if dst.Is6() {
+		lo, hi = MinIPv6MTU, max(p.max, MinIPv6MTU)
+	}
+	var rtt time.Duration
+	reported := false
+	fits := func(size int) (bool, error) {
+		d, err := p.echo(ctx, conn, dst, size)
+		var tooBig *errTooBig
+		reported = false
+		switch {
+		case err == nil:
+			rtt = d
+			return true, nil
+		case errors.As(err, &tooBig):
+			hi = size - 1
+			if tooBig.mtu >= lo && tooBig.mtu < size {
+				hi, reported = tooBig.mtu, true
+			}
+			return false, nil
+		case errors.Is(err, errNoReply):
+			hi = size - 1
+			return false, nil
+		}
+		return false, err
+	}
+
+	// most paths carry the largest size, so it is tried first, followed by
+	// the MTUs routers report
+	ok, err := fits(hi)
+	for err == nil && !ok && reported {
+		ok, err = fits(hi)
+	}
+	if err != nil {
+		return fail(err)
+	}
+	// without reports, as behind a blackhole, the MTU is searched for
+	if !ok {
+		if ok, err = fits(lo); err != nil {
+			return fail(err)
+		}
+		if !ok {
+			res.Latency = time.Since(res.Time).Seconds()
+			res.Status = "mtu_error"
+			res.ErrorReason = FailureDestinationUnreachable
+			return res, nil
+		}
+		for lo < hi {
+			mid := (lo + hi + 1) / 2
+			ok, err := fits(mid)
+			if err != nil {
+				return fail(err)
+			}
+			if ok {
+				lo = mid
+			}
+		}
+	}
+	res.MTU = hi
+	res.Latency = rtt.Seconds()
+
+	if res.MTU < p.threshold {
+		res.Status = "mtu_error"
+		res.ErrorReason = FailureMTUBelowThreshold
+	}
+	return res, nil
+}
+
+// echo sends echo requests of size bytes, IP header included, until one is
+// answered or attempts run out.
+func (p *mtuProber) echo(ctx context.Context, conn *net.IPConn, dst netip.Addr, size int) (time.Duration, error) {
+	var typ icmp.Type = ipv4.ICMPTypeEcho
+	proto, header := 1, 20
+	if dst.Is6() {
+		typ, proto, header = ipv6.ICMPTypeEchoRequest, 58, 40
+	}
+	buf := make([]byte, max(size, 1500))
+
+	for range p.attempts {
+		p.seq = (p.seq + 1) & 0xffff
+		data := make([]byte, size-header-8)
+		msg, _ := (&icmp.Message{Type: typ, Body: &icmp.Echo{ID: p.id, Seq: p.seq, Data: data}}).Marshal(nil)
+
+		start := time.Now()
+		deadline := start.Add(p.timeout)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		conn.SetReadDeadline(deadline)
+		if _, err := conn.WriteTo(msg, &net.IPAddr{IP: dst.AsSlice()}); err != nil {
+			if errors.Is(err, syscall.EMSGSIZE) {
+				return 0, &errTooBig{}
+			}
+			return 0, err
+		}
+
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() && ctx.Err() == nil {
+					break
+				}
+				return 0, err
+			}
+			answered, mtu := p.parse(buf[:n], proto, dst)
+			switch answered {
+			case mtuEchoReply:
+				return time.Since(start), nil
+			case mtuTooBig:
+				return 0, &errTooBig{mtu: mtu}
+			}
+		}
+	}
+	return 0, errNoReply
+}
+
+// Answers to an echo request of an MTU probe.
+const (
+	mtuOther = iota
+	mtuEchoReply
+	mtuTooBig
+)
+
+// parse classifies an ICMP message as the reply to the last echo request,
+// a report that it was too big along with the next-hop MTU, or neither.
+func (p *mtuProber) parse(b []byte, proto int, dst netip.Addr) (int, int) {
+	m, err := icmp.ParseMessage(proto, b)
+	if err != nil {
+		return mtuOther, 0
+	}
+	if echo, ok := m.Body.(*icmp.Echo); ok {
+		if (m.Type == ipv4.ICMPTypeEchoReply || m.Type == ipv6.ICMPTypeEchoReply) &&
+			echo.ID == p.id && echo.Seq == p.seq {
+			return mtuEchoReply, 0
+		}
+		return mtuOther, 0
+	}
+
+	// fragmentation needed carries the MTU in the second half of the
+	// otherwise unused word, packet too big in the whole of it
+	var mtu int
+	switch {
+	case m.Type == ipv4.ICMPTypeDestinationUnreachable && m.Code == 4:
+		mtu = int(binary.BigEndian.Uint16(b[6:8]))
+	case m.Type == ipv6.ICMPTypePacketTooBig:
+		mtu = int(binary.BigEndian.Uint32(b[4:8]))
+	default:
+		return mtuOther, 0
+	}
+	quotedDst, quotedProto, transport, ok := quotedPacket(b[8:])
+	if !ok || quotedDst != dst || quotedProto != proto ||
+		int(binary.BigEndian.Uint16(transport[4:6])) != p.id ||
+		int(binary.BigEndian.Uint16(transport[6:8])) != p.seq {
+		return mtuOther, 0
+	}
+	return mtuTooBig, mtu
+}
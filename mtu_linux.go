@@ -0,0 +1,39 @@
+// Copyright (c) 2025 Dakhil Y.
+package main
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"syscall"
+)
+
+// listenDontFragment opens a raw ICMP socket whose packets carry the
+// don't-fragment bit and are not limited by the kernel's cached path MTU,
+// so that the probe finds the MTU itself.
+func listenDontFragment(ctx context.Context, dst netip.Addr) (*net.IPConn, error) {
+	network, address := "ip4:icmp", "0.0.0.0"
+	level, opt, value := syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_PROBE
+	if dst.Is6() {
+		network, address = "ip6:ipv6-icmp", "::"
+		level, opt, value = syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER, syscall.IPV6_PMTUDISC_PROBE
+	}
+
+	lc := net.ListenConfig{
+		Control: func(_, _ string, c syscall.RawConn) error {
+			var err error
+			controlErr := c.Control(func(fd uintptr) {
+				err = syscall.SetsockoptInt(int(fd), level, opt, value)
+			})
+			if controlErr != nil {
+				return controlErr
+			}
+			return err
+		},
+	}
+	conn, err := lc.ListenPacket(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*net.IPConn), nil
+}
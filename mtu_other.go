@@ -0,0 +1,16 @@
+// Copyright (c) 2025 Dakhil Y.
+
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+)
+
+func listenDontFragment(context.Context, netip.Addr) (*net.IPConn, error) {
+	return nil, errors.New("path MTU discovery is only supported on Linux")
+}
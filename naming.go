@@ -0,0 +1,86 @@
+// Copyright (c) 2025 Dakhil Y.
+package main
+
+import (
+	"net"
+	"net/url"
+	"strings"
+)
+
+const RedactedValue = "REDACTED"
+
+// NamingConfig controls how a target's default name, used as the target
+// label, is derived from its URL. Credentials are always removed.
+type NamingConfig struct {
+	StripQuery   bool     `yaml:"strip_query"`
+	RedactParams []string `yaml:"redact_params"`
+}
+
+// name normalizes a URL into a target name: lower-case scheme and host, no
+// default port, credentials or fragment, and the query stripped or redacted
+// as configured. Unparseable URLs are returned unchanged and left for
+// validation to reject.
+func (n NamingConfig) name(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return raw
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	if host, port, err := net.SplitHostPort(u.Host); err == nil {
+		if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+			u.Host = host
+			if strings.Contains(host, ":") {
+				u.Host = "[" + host + "]"
+			}
+		}
+	}
+	u.User = nil
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	switch {
+	case n.StripQuery:
+		u.RawQuery = ""
+		u.ForceQuery = false
+	default:
+		n.redactQuery(u)
+	}
+	return u.String()
+}
+
+// redact removes the credentials and redacts the query parameters of a URL
+// like name does, but otherwise leaves it as written. It is applied to the
+// URLs the API returns.
+func (n NamingConfig) redact(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	u.User = nil
+	n.redactQuery(u)
+	return u.String()
+}
+
+func (n NamingConfig) redactQuery(u *url.URL) {
+	if len(n.RedactParams) == 0 || u.RawQuery == "" {
+		return
+	}
+	q := u.Query()
+	for key := range q {
+		if n.redacted(key) {
+			q[key] = []string{RedactedValue}
+		}
+	}
+	u.RawQuery = q.Encode()
+}
+
+func (n NamingConfig) redacted(param string) bool {
+	for _, p := range n.RedactParams {
+		if strings.EqualFold(p, param) {
+			return true
+		}
+	}
+	return false
+}
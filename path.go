@@ -0,0 +1,619 @@
+// Copyright (c) 2025 Dakhil Y.
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// Protocols a path probe sends its TTL-limited packets over.
+const (
+	PathProtocolICMP = "icmp"
+	PathProtocolUDP  = "udp"
+	PathProtocolTCP  = "tcp"
+
+	DefaultPathMaxHops = 30
+	MaxPathHops        = 64
+	DefaultPathCount   = 3
+	MaxPathCount       = 10
+	DefaultPathTimeout = time.Second
+	// DefaultPathInterval spaces out path probes, which send up to
+	// max_hops × count packets each.
+	DefaultPathInterval = time.Minute
+
+	// DefaultTraceroutePort is the first destination port of UDP probes.
+	DefaultTraceroutePort = 33434
+	DefaultPathTCPPort    = 80
+)
+
+// PathOptions configures a traceroute://host target. Each probe sends Count
+// rounds of packets with increasing TTLs and waits up to Timeout per round
+// for the routers along the way to answer, like mtr. Their answers are read
+// from a raw ICMP socket, so netpulse must run as root or with CAP_NET_RAW.
+type PathOptions struct {
+	Protocol string   `yaml:"protocol" json:"protocol,omitempty"`
+	MaxHops  int      `yaml:"max_hops" json:"max_hops,omitempty"`
+	Count    int      `yaml:"count" json:"count,omitempty"`
+	Timeout  Duration `yaml:"timeout" json:"timeout,omitempty"`
+}
+
+func (o *PathOptions) validate(t *Target) error {
+	u, err := url.Parse(t.URL)
+	if err != nil {
+		return err
+	}
+	if u.Path != "" && u.Path != "/" {
+		return fmt.Errorf("invalid url %q: traceroute url must not have a path", t.URL)
+	}
+	if o == nil {
+		return nil
+	}
+	switch o.Protocol {
+	case "", PathProtocolICMP, PathProtocolUDP, PathProtocolTCP:
+	default:
+		return fmt.Errorf("invalid path.protocol %q, want icmp, udp or tcp", o.Protocol)
+	}
+	if o.Protocol == PathProtocolICMP && u.Port() != "" {
+		return fmt.Errorf("invalid url %q: icmp traceroute url must not have a port", t.URL)
+	}
+	if o.MaxHops < 0 || o.MaxHops > MaxPathHops {
+		return fmt.Errorf("path.max_hops must be between 1 and %d", MaxPathHops)
+	}
+	if o.Count < 0 || o.Count > MaxPathCount {
+		return fmt.Errorf("path.count must be between 1 and %d", MaxPathCount)
+	}
+	if o.Timeout < 0 {
+		return errors.New("path.timeout must not be negative")
+	}
+	if o.Protocol == PathProtocolUDP && u.Port() != "" {
+		// each packet of a run goes to its own port above the URL port
+		port, _ := strconv.Atoi(u.Port())
+		maxHops, count := cmp.Or(o.MaxHops, DefaultPathMaxHops), cmp.Or(o.Count, DefaultPathCount)
+		if port+maxHops*count-1 > 0xffff {
+			return fmt.Errorf("invalid url %q: udp traceroute needs %d ports from the url port", t.URL, maxHops*count)
+		}
+	}
+	return nil
+}
+
+// Hop is one TTL of a path probe. Address is the router that answered, or
+// empty when none did.
+type Hop struct {
+	TTL     int     `json:"hop"`
+	Address string  `json:"address,omitempty"`
+	Latency float64 `json:"latency_seconds"`
+	Sent    int     `json:"sent"`
+	Lost    int     `json:"lost"`
+}
+
+type pathProber struct {
+	target   Target
+	host     string
+	port     int
+	protocol string
+	maxHops  int
+	count    int
+	timeout  time.Duration
+	// id is the ICMP echo identifier of this target
+	id  int
+	seq int
+
+	// last holds the hop addresses of the previous probe
+	last []string
+}
+
+func newPathProber(t Target) (probeFunc, func()) {
+	var opts PathOptions
+	if t.Path != nil {
+		opts = *t.Path
+	}
+
+	u, _ := url.Parse(t.URL)
+	p := &pathProber{
+		target:   t,
+		host:     u.Hostname(),
+		protocol: opts.Protocol,
+		maxHops:  opts.MaxHops,
+		count:    opts.Count,
+		timeout:  time.Duration(opts.Timeout),
+		id:       rand.IntN(1 << 16),
+	}
+	if t.Address != "" {
+		p.host, _, _ = net.SplitHostPort(t.Address)
+	}
+	if p.protocol == "" {
+		p.protocol = PathProtocolICMP
+	}
+	p.port, _ = strconv.Atoi(u.Port())
+	if p.port == 0 {
+		p.port = DefaultTraceroutePort
+		if p.protocol == PathProtocolTCP {
+			p.port = DefaultPathTCPPort
+		}
+	}
+	if p.maxHops == 0 {
+		p.maxHops = DefaultPathMaxHops
+	}
+	if p.count == 0 {
+		p.count = DefaultPathCount
+	}
+	if p.timeout == 0 {
+		p.timeout = DefaultPathTimeout
+	}
+	return p.probe, func() {}
+}
+
+// pathReply is an answer to the packet sent with key. Reached is set when
+// the destination answered, terminal when a router reported it unreachable.
+type pathReply struct {
+	key      int
+	from     netip.Addr
+	at       time.Time
+	reached  bool
+	terminal bool
+}
+
+type pathSend struct {
+	ttl int
+	at  time.Time
+}
+
+// pathRun is the state of one probe.
+type pathRun struct {
+	*pathProber
+	dst     netip.Addr
+	icmp    *icmp.PacketConn
+	udp     net.PacketConn
+	replies chan pathReply
+	done    chan struct{}
+	// ports counts the UDP datagrams sent
+	ports int
+
+	mu   sync.Mutex
+	sent map[int]pathSend
+}
+
+// probe reports the mean round trip time to the destination as its latency
+// and the packets sent to and lost on the way to it as attempts.
+func (p *pathProber) probe(ctx context.Context) (Result, error) {
+	res := Result{
+		Target:      p.target.Name,
+		Time:        time.Now(),
+		Status:      "success",
+		ErrorReason: FailureNone,
+	}
+	fail := func(err error) (Result, error) {
+		res.Latency = time.Since(res.Time).Seconds()
+		res.Status = "transport_error"
+		res.ErrorReason = classifyTransportError(err)
+		return res, err
+	}
+
+	dst, err := resolveHost(ctx, p.host, p.target.IPVersion)
+	if err != nil {
+		return fail(err)
+	}
+	res.IPVersion = addrFamily(dst)
+
+	r := &pathRun{
+		pathProber: p,
+		dst:        dst,
+		replies:    make(chan pathReply, 2*p.maxHops),
+		done:       make(chan struct{}),
+		sent:       make(map[int]pathSend),
+	}
+	defer close(r.done)
+	if err := r.listen(); err != nil {
+		return fail(err)
+	}
+	defer r.close()
+
+	hops := make([]Hop, p.maxHops)
+	addrs := make([]string, p.maxHops)
+	received := make([]int, p.maxHops)
+	rtts := make([]time.Duration, p.maxHops)
+	// the first TTL reaching the destination, or a router reporting it
+	// unreachable
+	last, reached := p.maxHops, false
+
+	for range p.count {
+		for ttl := 1; ttl <= last; ttl++ {
+			if err := r.send(ctx, ttl); err != nil {
+				return fail(err)
+			}
+			hops[ttl-1].Sent++
+		}
+
+		timer := time.NewTimer(p.timeout)
+	wait:
+		for {
+			select {
+			case reply := <-r.replies:
+				s, ok := r.take(reply.key)
+				if !ok {
+					continue
+				}
+				i := s.ttl - 1
+				received[i]++
+				rtts[i] += reply.at.Sub(s.at)
+				if addrs[i] == "" {
+					addrs[i] = reply.from.String()
+				}
+				if (reply.reached || reply.terminal) && s.ttl <= last {
+					last, reached = s.ttl, reply.reached
+				}
+				if r.outstanding(last) == 0 {
+					break wait
+				}
+			case <-timer.C:
+				break wait
+			case <-ctx.Done():
+				timer.Stop()
+				return fail(ctx.Err())
+			}
+		}
+		timer.Stop()
+		r.reset()
+	}
+
+	// without an answer from the destination the path ends at the last
+	// router that answered
+	if !reached && last == p.maxHops {
+		for last > 0 && addrs[last-1] == "" {
+			last--
+		}
+	}
+	res.Hops = hops[:last]
+	path := addrs[:last]
+	for i := range res.Hops {
+		h := &res.Hops[i]
+		h.TTL = i + 1
+		h.Address = addrs[i]
+		h.Lost = h.Sent - received[i]
+		if received[i] > 0 {
+			h.Latency = (rtts[i] / time.Duration(received[i])).Seconds()
+		}
+	}
+
+	if p.last != nil && pathChanged(p.last, path) {
+		res.PathChanged = true
+		fmt.Printf("Path to %s changed: %s -> %s\n", p.target.Name, formatPath(p.last), formatPath(path))
+	}
+	p.last = path
+
+	if !reached {
+		res.Latency = time.Since(res.Time).Seconds()
+		res.Status = "path_error"
+		res.ErrorReason = FailureDestinationUnreachable
+		return res, nil
+	}
+	dest := res.Hops[last-1]
+	res.Latency = dest.Latency
+	res.Attempts = dest.Sent
+	res.Lost = dest.Lost
+	return res, nil
+}
+
+// resolveHost returns the address of host for probes sending packets
+// themselves, restricted to the given address family.
+func resolveHost(ctx context.Context, host, ipVersion string) (netip.Addr, error) {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return addr.Unmap(), nil
+	}
+	network := "ip"
+	switch ipVersion {
+	case IPVersion4:
+		network = "ip4"
+	case IPVersion6:
+		network = "ip6"
+	}
+	ctx, cancel := context.WithTimeout(ctx, DNSLookupTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, network, host)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return addrs[0].Unmap(), nil
+}
+
+// listen opens the raw ICMP socket receiving the answers of routers, and
+// for UDP probes the socket sending them.
+func (r *pathRun) listen() error {
+	network, address := "ip4:icmp", "0.0.0.0"
+	if r.dst.Is6() {
+		network, address = "ip6:ipv6-icmp", "::"
+	}
+	c, err := icmp.ListenPacket(network, address)
+	if err != nil {
+		return err
+	}
+	r.icmp = c
+
+	if r.protocol == PathProtocolUDP {
+		r.udp, err = net.ListenPacket(dialNetwork("udp", addrFamily(r.dst)), ":0")
+		if err != nil {
+			c.Close()
+			return err
+		}
+	}
+	go r.read()
+	return nil
+}
+
+func (r *pathRun) close() {
+	r.icmp.Close()
+	if r.udp != nil {
+		r.udp.Close()
+	}
+}
+
+// read passes the ICMP messages answering this run's packets to replies
+// until the socket is closed.
+func (r *pathRun) read() {
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := r.icmp.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		reply, ok := r.parse(buf[:n])
+		if !ok {
+			continue
+		}
+		reply.at = time.Now()
+		reply.from, _ = netip.AddrFromSlice(from.(*net.IPAddr).IP)
+		reply.from = reply.from.Unmap()
+		reply.reached = reply.reached || reply.from == r.dst
+		select {
+		case r.replies <- reply:
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// ICMP message types a path probe handles.
+var (
+	pathEchoReply   = map[icmp.Type]bool{ipv4.ICMPTypeEchoReply: true, ipv6.ICMPTypeEchoReply: true}
+	pathTimeExpired = map[icmp.Type]bool{ipv4.ICMPTypeTimeExceeded: true, ipv6.ICMPTypeTimeExceeded: true}
+	pathUnreachable = map[icmp.Type]bool{
+		ipv4.ICMPTypeDestinationUnreachable: true,
+		ipv6.ICMPTypeDestinationUnreachable: true,
+	}
+)
+
+// parse matches an ICMP message to a packet of this run. Errors quote the
+// IP header and first 8 bytes of the packet that caused them.
+func (r *pathRun) parse(b []byte) (pathReply, bool) {
+	proto := 1
+	if r.dst.Is6() {
+		proto = 58
+	}
+	m, err := icmp.ParseMessage(proto, b)
+	if err != nil {
+		return pathReply{}, false
+	}
+
+	var quoted []byte
+	switch body := m.Body.(type) {
+	case *icmp.Echo:
+		if !pathEchoReply[m.Type] || body.ID != r.id || r.protocol != PathProtocolICMP {
+			return pathReply{}, false
+		}
+		return pathReply{key: body.Seq, reached: true}, true
+	case *icmp.TimeExceeded:
+		if !pathTimeExpired[m.Type] {
+			return pathReply{}, false
+		}
+		quoted = body.Data
+	case *icmp.DstUnreach:
+		if !pathUnreachable[m.Type] {
+			return pathReply{}, false
+		}
+		quoted = body.Data
+	default:
+		return pathReply{}, false
+	}
+
+	key, ok := r.quotedKey(quoted)
+	return pathReply{key: key, terminal: pathUnreachable[m.Type]}, ok
+}
+
+// quotedKey returns the key of the packet quoted in an ICMP error, if it is
+// one this run sent.
+func (r *pathRun) quotedKey(b []byte) (int, bool) {
+	dst, proto, transport, ok := quotedPacket(b)
+	if !ok || dst != r.dst {
+		return 0, false
+	}
+
+	srcPort := int(binary.BigEndian.Uint16(transport[0:2]))
+	dstPort := int(binary.BigEndian.Uint16(transport[2:4]))
+	switch {
+	case r.protocol == PathProtocolICMP && (proto == 1 || proto == 58):
+		if int(binary.BigEndian.Uint16(transport[4:6])) != r.id {
+			return 0, false
+		}
+		return int(binary.BigEndian.Uint16(transport[6:8])), true
+	case r.protocol == PathProtocolUDP && proto == syscall.IPPROTO_UDP:
+		if srcPort != r.udp.LocalAddr().(*net.UDPAddr).Port {
+			return 0, false
+		}
+		return dstPort, true
+	case r.protocol == PathProtocolTCP && proto == syscall.IPPROTO_TCP:
+		if dstPort != r.port {
+			return 0, false
+		}
+		return srcPort, true
+	}
+	return 0, false
+}
+
+// quotedPacket returns the destination, protocol and first 8 transport
+// bytes of the IPv4 or IPv6 packet quoted in an ICMP error.
+func quotedPacket(b []byte) (dst netip.Addr, proto int, transport []byte, ok bool) {
+	switch {
+	case len(b) >= 20 && b[0]>>4 == 4:
+		ihl := int(b[0]&0x0f) * 4
+		if len(b) < ihl+8 {
+			return netip.Addr{}, 0, nil, false
+		}
+		dst, _ = netip.AddrFromSlice(b[16:20])
+		return dst, int(b[9]), b[ihl:], true
+	case len(b) >= 48 && b[0]>>4 == 6:
+		dst, _ = netip.AddrFromSlice(b[24:40])
+		return dst, int(b[6]), b[40:], true
+	}
+	return netip.Addr{}, 0, nil, false
+}
+
+// send sends one packet with the given TTL. ICMP echo requests are keyed by
+// sequence number, UDP datagrams by destination port and TCP connection
+// attempts by source port.
+func (r *pathRun) send(ctx context.Context, ttl int) error {
+	switch r.protocol {
+	case PathProtocolICMP:
+		r.seq = (r.seq + 1) & 0xffff
+		var typ icmp.Type = ipv4.ICMPTypeEcho
+		if r.dst.Is6() {
+			typ = ipv6.ICMPTypeEchoRequest
+		}
+		msg, _ := (&icmp.Message{Type: typ, Body: &icmp.Echo{ID: r.id, Seq: r.seq, Data: []byte("netpulse")}}).Marshal(nil)
+		if err := setHopLimit(r.icmp, r.dst, ttl); err != nil {
+			return err
+		}
+		r.register(r.seq, ttl)
+		_, err := r.icmp.WriteTo(msg, &net.IPAddr{IP: r.dst.AsSlice()})
+		return err
+
+	case PathProtocolUDP:
+		// one port per packet of the run, so that late answers are not
+		// mistaken for those of the next round; every run sends from a new
+		// socket, so the ports start over
+		port := r.port + r.ports%(r.maxHops*r.count)
+		r.ports++
+		if err := setHopLimit(r.udp, r.dst, ttl); err != nil {
+			return err
+		}
+		r.register(port, ttl)
+		_, err := r.udp.WriteTo([]byte("netpulse"), &net.UDPAddr{IP: r.dst.AsSlice(), Port: port})
+		return err
+	}
+
+	// the connection attempt runs in the background; routers answer the
+	// SYN like any other packet and the destination by accepting or
+	// refusing it
+	var port int
+	dialer := &net.Dialer{
+		Timeout: r.timeout,
+		Control: tcpTTLControl(ttl, func(bound int) {
+			port = bound
+			r.register(port, ttl)
+		}),
+	}
+	addr := netip.AddrPortFrom(r.dst, uint16(r.port)).String()
+	go func() {
+		conn, err := dialer.DialContext(ctx, dialNetwork("tcp", addrFamily(r.dst)), addr)
+		switch {
+		case err == nil:
+			conn.Close()
+		case errors.Is(err, syscall.ECONNREFUSED):
+		default:
+			return
+		}
+		select {
+		case r.replies <- pathReply{key: port, from: r.dst, at: time.Now(), reached: true}:
+		case <-r.done:
+		}
+	}()
+	return nil
+}
+
+func (r *pathRun) register(key, ttl int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sent[key] = pathSend{ttl: ttl, at: time.Now()}
+}
+
+// take returns and forgets the packet sent with key.
+func (r *pathRun) take(key int) (pathSend, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.sent[key]
+	delete(r.sent, key)
+	return s, ok
+}
+
+// outstanding counts the unanswered packets of TTLs up to last.
+func (r *pathRun) outstanding(last int) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, s := range r.sent {
+		if s.ttl <= last {
+			n++
+		}
+	}
+	return n
+}
+
+// reset forgets the unanswered packets of a round.
+func (r *pathRun) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	clear(r.sent)
+}
+
+func setHopLimit(c net.PacketConn, dst netip.Addr, ttl int) error {
+	if ic, ok := c.(*icmp.PacketConn); ok {
+		if dst.Is6() {
+			return ic.IPv6PacketConn().SetHopLimit(ttl)
+		}
+		return ic.IPv4PacketConn().SetTTL(ttl)
+	}
+	if dst.Is6() {
+		return ipv6.NewPacketConn(c).SetHopLimit(ttl)
+	}
+	return ipv4.NewPacketConn(c).SetTTL(ttl)
+}
+
+// pathChanged reports whether two paths differ, ignoring hops that did not
+// answer in either.
+func pathChanged(a, b []string) bool {
+	if len(a) != len(b) {
+		return true
+	}
+	for i := range a {
+		if a[i] != "" && b[i] != "" && a[i] != b[i] {
+			return true
+		}
+	}
+	return false
+}
+
+func formatPath(path []string) string {
+	hops := make([]string, len(path))
+	for i, addr := range path {
+		hops[i] = addr
+		if addr == "" {
+			hops[i] = "*"
+		}
+	}
+	return strings.Join(hops, " ")
+}
@@ -0,0 +1,16 @@
+// Copyright (c) 2025 Dakhil Y.
+
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+func tcpTTLControl(int, func(int)) func(network, address string, c syscall.RawConn) error {
+	return func(string, string, syscall.RawConn) error {
+		return errors.New("tcp traceroute is not supported on this platform")
+	}
+}
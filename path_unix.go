@@ -0,0 +1,48 @@
+// Copyright (c) 2025 Dakhil Y.
+
+//go:build unix
+
+package main
+
+import (
+	"strings"
+	"syscall"
+)
+
+// tcpTTLControl returns a dialer control function limiting the TTL of a
+// connection attempt. It binds the socket to an ephemeral port before the
+// connect, so that routers' answers quoting the SYN can be matched, and
+// passes the port to bound.
+func tcpTTLControl(ttl int, bound func(port int)) func(network, address string, c syscall.RawConn) error {
+	return func(network, _ string, c syscall.RawConn) error {
+		var err error
+		controlErr := c.Control(func(fd uintptr) {
+			s := int(fd)
+			var sa syscall.Sockaddr = &syscall.SockaddrInet4{}
+			level, opt := syscall.IPPROTO_IP, syscall.IP_TTL
+			if strings.HasSuffix(network, "6") {
+				sa = &syscall.SockaddrInet6{}
+				level, opt = syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS
+			}
+			if err = syscall.SetsockoptInt(s, level, opt, ttl); err != nil {
+				return
+			}
+			if err = syscall.Bind(s, sa); err != nil {
+				return
+			}
+			if sa, err = syscall.Getsockname(s); err != nil {
+				return
+			}
+			switch sa := sa.(type) {
+			case *syscall.SockaddrInet4:
+				bound(sa.Port)
+			case *syscall.SockaddrInet6:
+				bound(sa.Port)
+			}
+		})
+		if controlErr != nil {
+			return controlErr
+		}
+		return err
+	}
+}
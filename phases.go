@@ -0,0 +1,141 @@
+// Copyright (c) 2025 Dakhil Y.
+package main
+
+import (
+	"crypto/tls"
+	"net/http/httptrace"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// Phases breaks the latency of an HTTP probe down into its connection phases,
+// in seconds. Phases that did not happen, such as DNS on a reused connection,
+// are zero. Transfer is the time it took to read the body after the headers.
+type Phases struct {
+	DNS       float64 `json:"dns_seconds"`
+	Connect   float64 `json:"connect_seconds"`
+	TLS       float64 `json:"tls_seconds"`
+	FirstByte float64 `json:"first_byte_seconds"`
+	Transfer  float64 `json:"transfer_seconds"`
+}
+
+// phaseTracer records phase timestamps from an httptrace.ClientTrace, and
+// the addresses the dialer tried so that a Happy Eyeballs fallback to the
+// other address family can be told apart.
+type phaseTracer struct {
+	mu                  sync.Mutex
+	dnsStart, dnsDone   time.Time
+	connStart, connDone time.Time
+	tlsStart, tlsDone   time.Time
+	firstByte           time.Time
+
+	firstAddr, connAddr string
+	gotConn, reused     bool
+}
+
+func (p *phaseTracer) trace() *httptrace.ClientTrace {
+	mark := func(t *time.Time) {
+		p.mu.Lock()
+		*t = time.Now()
+		p.mu.Unlock()
+	}
+
+	return &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { mark(&p.dnsStart) },
+		DNSDone:              func(httptrace.DNSDoneInfo) { mark(&p.dnsDone) },
+		ConnectStart:         p.connectStart,
+		ConnectDone:          p.connectDone,
+		TLSHandshakeStart:    func() { mark(&p.tlsStart) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { mark(&p.tlsDone) },
+		GotConn:              p.gotConnection,
+		GotFirstResponseByte: func() { mark(&p.firstByte) },
+	}
+}
+
+func (p *phaseTracer) gotConnection(info httptrace.GotConnInfo) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.gotConn, p.reused = true, info.Reused
+}
+
+// connection returns whether the request opened a new connection or reused
+// an idle one, or "" when it got none.
+func (p *phaseTracer) connection() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch {
+	case !p.gotConn:
+		return ""
+	case p.reused:
+		return ConnectionReused
+	}
+	return ConnectionNew
+}
+
+// connectStart keeps the first of possibly several racing connection
+// attempts, so that the connect phase covers any fallback.
+func (p *phaseTracer) connectStart(_, addr string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.firstAddr == "" {
+		p.connStart = time.Now()
+		p.firstAddr = addr
+	}
+}
+
+// connectDone keeps the attempt that succeeded, or the first failure when
+// none did.
+func (p *phaseTracer) connectDone(_, addr string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err == nil && p.connAddr == "" {
+		p.connDone = time.Now()
+		p.connAddr = addr
+	} else if p.connDone.IsZero() {
+		p.connDone = time.Now()
+	}
+}
+
+// family returns the address family of the new connection, and whether it
+// differs from the family the dialer tried first. ipVersion is empty when an
+// idle connection was reused.
+func (p *phaseTracer) family() (ipVersion string, fallback bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	conn, err := netip.ParseAddrPort(p.connAddr)
+	if err != nil {
+		return "", false
+	}
+	first, err := netip.ParseAddrPort(p.firstAddr)
+	if err != nil {
+		return addrFamily(conn.Addr()), false
+	}
+	return addrFamily(conn.Addr()), addrFamily(conn.Addr()) != addrFamily(first.Addr())
+}
+
+// phases converts the recorded timestamps into durations. First byte is
+// measured from start, the other phases from their own start.
+func (p *phaseTracer) phases(start time.Time) *Phases {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	span := func(from, to time.Time) float64 {
+		if from.IsZero() || to.IsZero() {
+			return 0
+		}
+		return to.Sub(from).Seconds()
+	}
+
+	return &Phases{
+		DNS:       span(p.dnsStart, p.dnsDone),
+		Connect:   span(p.connStart, p.connDone),
+		TLS:       span(p.tlsStart, p.tlsDone),
+		FirstByte: span(start, p.firstByte),
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2025 Dakhil Y.
+package main
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// Probe kinds. The kind of a target follows from its URL scheme.
+const (
+	KindHTTP      = "http"
+	KindGRPC      = "grpc"
+	KindWebSocket = "websocket"
+	KindUDP       = "udp"
+	KindSMTP      = "smtp"
+	KindIMAP      = "imap"
+	KindPOP3      = "pop3"
+	KindPostgres  = "postgres"
+	KindMySQL     = "mysql"
+	KindRedis     = "redis"
+	KindPath      = "path"
+	KindMTU       = "mtu"
+)
+
+var schemeKinds = map[string]string{
+	"http":  KindHTTP,
+	"https": KindHTTP,
+	"grpc":  KindGRPC,
+	"grpcs": KindGRPC,
+	"ws":    KindWebSocket,
+	"wss":   KindWebSocket,
+	"udp":   KindUDP,
+	"smtp":  KindSMTP,
+	"smtps": KindSMTP,
+	"imap":  KindIMAP,
+	"imaps": KindIMAP,
+	"pop3":  KindPOP3,
+	"pop3s": KindPOP3,
+
+	"postgres":   KindPostgres,
+	"postgresql": KindPostgres,
+	"mysql":      KindMySQL,
+	"redis":      KindRedis,
+	"rediss":     KindRedis,
+	"traceroute": KindPath,
+	"mtu":        KindMTU,
+}
+
+// defaultPorts are the ports of schemes that do not require one.
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+	"ws":    "80",
+	"wss":   "443",
+	"smtp":  "25",
+	"smtps": "465",
+	"imap":  "143",
+	"imaps": "993",
+	"pop3":  "110",
+	"pop3s": "995",
+
+	"postgres":   "5432",
+	"postgresql": "5432",
+	"mysql":      "3306",
+	"redis":      "6379",
+	"rediss":     "6379",
+}
+
+// hostPort returns the host and port of u, filling in the scheme's default
+// port.
+func hostPort(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = defaultPorts[u.Scheme]
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// kind returns the probe kind of t, or "" for an unsupported scheme.
+func (t *Target) kind() string {
+	u, err := url.Parse(t.URL)
+	if err != nil {
+		return ""
+	}
+	return schemeKinds[strings.ToLower(u.Scheme)]
+}
+
+// probeFunc runs one probe of a target.
+type probeFunc func(ctx context.Context) (Result, error)
+
+// newProber returns the probe function for t and a function releasing the
+// connections it keeps between probes.
+func newProber(t Target) (probeFunc, func()) {
+	switch t.kind() {
+	case KindGRPC:
+		return newGRPCProber(t)
+	case KindWebSocket:
+		return newWebSocketProber(t)
+	case KindUDP:
+		return newUDPProber(t)
+	case KindSMTP, KindIMAP, KindPOP3:
+		return newMailProber(t)
+	case KindPostgres, KindMySQL, KindRedis:
+		return newDatabaseProber(t)
+	case KindPath:
+		return newPathProber(t)
+	case KindMTU:
+		return newMTUProber(t)
+	}
+	if t.Script != nil {
+		return newScriptProber(t)
+	}
+	if t.Throughput != nil {
+		return newThroughputProber(t)
+	}
+
+	client := newHTTPClient(t)
+	run := func(ctx context.Context) (Result, error) {
+		return probeHTTP(ctx, t, client)
+	}
+	if client == httpClient {
+		return run, func() {}
+	}
+	return run, client.CloseIdleConnections
+}
@@ -0,0 +1,235 @@
+// Copyright (c) 2025 Dakhil Y.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/netip"
+	"net/url"
+	"strings"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// HTTP protocols, named like their ALPN identifiers. h2c is HTTP/2 without
+// TLS, spoken from the first byte.
+const (
+	ProtocolHTTP1 = "http/1.1"
+	ProtocolH2    = "h2"
+	ProtocolH2C   = "h2c"
+	ProtocolH3    = "h3"
+)
+
+// Connection modes of http and https targets. ConnectionNew and
+// ConnectionReused are also the values of the connection label.
+const (
+	ConnectionReuse  = "reuse"
+	ConnectionNew    = "new"
+	ConnectionBoth   = "both"
+	ConnectionReused = "reused"
+)
+
+// HTTPOptions configures how http and https targets are fetched. Protocol
+// forces one HTTP version instead of letting the server pick; a server that
+// does not speak it fails the probe. MaxBodySize caps how many bytes of the
+// response body are read, DefaultMaxBodySize by default. Connection keeps connections alive
+// between probes (reuse, the default), opens a new one for every probe
+// (new), or does both in turn within each probe (both).
+type HTTPOptions struct {
+	Protocol    string `yaml:"protocol" json:"protocol,omitempty"`
+	Connection  string `yaml:"connection" json:"connection,omitempty"`
+	MaxBodySize int64  `yaml:"max_body_size" json:"max_body_size,omitempty"`
+}
+
+func (o *HTTPOptions) validate(t *Target) error {
+	if o == nil {
+		return nil
+	}
+	u, err := url.Parse(t.URL)
+	if err != nil {
+		return err
+	}
+	switch o.Protocol {
+	case "", ProtocolHTTP1:
+	case ProtocolH2, ProtocolH3:
+		if u.Scheme != "https" {
+			return fmt.Errorf("http.protocol %s requires an https url", o.Protocol)
+		}
+	case ProtocolH2C:
+		if u.Scheme != "http" {
+			return fmt.Errorf("http.protocol %s requires an http url", o.Protocol)
+		}
+	default:
+		return fmt.Errorf("http.protocol must be %q, %q, %q or %q",
+			ProtocolHTTP1, ProtocolH2, ProtocolH2C, ProtocolH3)
+	}
+	switch o.Connection {
+	case "", ConnectionReuse, ConnectionNew:
+	case ConnectionBoth:
+		if t.Script != nil {
+			return fmt.Errorf("http.connection %s cannot be used with a script", o.Connection)
+		}
+	default:
+		return fmt.Errorf("http.connection must be %q, %q or %q", ConnectionReuse, ConnectionNew, ConnectionBoth)
+	}
+	if o.MaxBodySize < 0 {
+		return errors.New("http.max_body_size must not be negative")
+	}
+	return nil
+}
+
+// httpProtocol returns the protocol forced for t, or "".
+func (t Target) httpProtocol() string {
+	if t.HTTP == nil {
+		return ""
+	}
+	return t.HTTP.Protocol
+}
+
+// httpConnection returns the connection mode of t.
+func (t Target) httpConnection() string {
+	if t.HTTP == nil || t.HTTP.Connection == "" {
+		return ConnectionReuse
+	}
+	return t.HTTP.Connection
+}
+
+// maxBodySize returns how much of a response body to read for t.
+func (t Target) maxBodySize() int64 {
+	if t.HTTP == nil || t.HTTP.MaxBodySize == 0 {
+		return DefaultMaxBodySize
+	}
+	return t.HTTP.MaxBodySize
+}
+
+// setProtocols restricts a transport to the given protocol.
+func setProtocols(transport *http.Transport, protocol string) {
+	if protocol == "" {
+		return
+	}
+	// a transport that has been used offers h2 in its TLS config whatever
+	// its protocols, and clones inherit that
+	if transport.TLSClientConfig != nil {
+		transport.TLSClientConfig.NextProtos = nil
+	}
+	transport.Protocols = new(http.Protocols)
+	switch protocol {
+	case ProtocolHTTP1:
+		transport.Protocols.SetHTTP1(true)
+	case ProtocolH2:
+		transport.Protocols.SetHTTP2(true)
+	case ProtocolH2C:
+		transport.Protocols.SetUnencryptedHTTP2(true)
+	}
+}
+
+// newHTTP3Transport returns a transport speaking HTTP/3 to t. Its dial
+// honours t's pinned address and address family, and reports the QUIC
+// handshake as both the connect and the TLS phase. The transport itself
+// reports whether a request got a new or a reused connection.
+func newHTTP3Transport(t Target) *http3.Transport {
+	return &http3.Transport{
+		Dial: func(ctx context.Context, addr string, tlsConf *tls.Config, conf *quic.Config) (*quic.Conn, error) {
+			if t.Address != "" {
+				addr = t.Address
+			}
+			host, port, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			ip, err := resolveHost(ctx, host, t.IPVersion)
+			if err != nil {
+				return nil, err
+			}
+			dst := net.UDPAddrFromAddrPort(netip.AddrPortFrom(ip, 0))
+			if dst.Port, err = net.LookupPort("udp", port); err != nil {
+				return nil, err
+			}
+
+			network := "udp4"
+			if ip.Is6() {
+				network = "udp6"
+			}
+			pconn, err := net.ListenUDP(network, nil)
+			if err != nil {
+				return nil, err
+			}
+
+			trace := httptrace.ContextClientTrace(ctx)
+			if trace != nil && trace.ConnectStart != nil {
+				trace.ConnectStart(network, dst.String())
+			}
+			if trace != nil && trace.TLSHandshakeStart != nil {
+				trace.TLSHandshakeStart()
+			}
+			conn, err := quic.Dial(ctx, pconn, dst, tlsConf, conf)
+			if trace != nil && trace.TLSHandshakeDone != nil {
+				var state tls.ConnectionState
+				if conn != nil {
+					state = conn.ConnectionState().TLS
+				}
+				trace.TLSHandshakeDone(state, err)
+			}
+			if trace != nil && trace.ConnectDone != nil {
+				trace.ConnectDone(network, dst.String(), err)
+			}
+			if err != nil {
+				pconn.Close()
+				return nil, err
+			}
+			// the socket belongs to the connection alone
+			go func() {
+				<-conn.Context().Done()
+				pconn.Close()
+			}()
+			return conn, nil
+		},
+	}
+}
+
+// responseProtocol returns the protocol a response arrived over.
+func responseProtocol(resp *http.Response) string {
+	switch {
+	case resp.ProtoMajor == 3:
+		return ProtocolH3
+	case resp.ProtoMajor == 2 && resp.TLS == nil:
+		return ProtocolH2C
+	case resp.ProtoMajor == 2:
+		return ProtocolH2
+	}
+	return strings.ToLower(resp.Proto)
+}
+
+// protocolNegotiated reports whether a response arrived over the protocol
+// forced for t. HTTP/1.0 answers count as HTTP/1.1.
+func protocolNegotiated(t Target, resp *http.Response) bool {
+	switch t.httpProtocol() {
+	case ProtocolHTTP1:
+		return resp.ProtoMajor == 1
+	case ProtocolH2, ProtocolH2C:
+		return resp.ProtoMajor == 2
+	case ProtocolH3:
+		return resp.ProtoMajor == 3
+	}
+	return true
+}
+
+// classifyHTTPError classifies a failed request, telling a server that
+// refused the protocol forced for t apart from other failures: a TLS or
+// QUIC handshake without a common application protocol, or a QUIC version
+// mismatch.
+func classifyHTTPError(t Target, err error) string {
+	if t.httpProtocol() != "" {
+		var versionErr *quic.VersionNegotiationError
+		if errors.As(err, &versionErr) || strings.Contains(err.Error(), "no application protocol") {
+			return FailureProtocolNotNegotiated
+		}
+	}
+	return classifyTransportError(err)
+}
@@ -0,0 +1,370 @@
+// Copyright (c) 2025 Dakhil Y.
+package main
+
+import (
+	"crypto/tls"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Result is the outcome of a single probe against a target.
+type Result struct {
+	Target      string    `json:"target"`
+	Time        time.Time `json:"time"`
+	Status      string    `json:"status"`
+	ErrorReason string    `json:"error_reason"`
+	Code        int       `json:"code,omitempty"`
+	Latency     float64   `json:"latency_seconds"`
+	Phases      *Phases   `json:"phases,omitempty"`
+
+	// IPVersion is the address family of a newly opened connection, and
+	// Fallback whether Happy Eyeballs fell back to it from the other one.
+	IPVersion string `json:"ip_version,omitempty"`
+	Fallback  bool   `json:"fallback,omitempty"`
+
+	// Protocol is the HTTP protocol of the response, such as h2, and
+	// Connection whether it came over a new connection or a reused one.
+	// ReusedLatency is the latency of the second request of HTTP probes
+	// that measure both.
+	Protocol      string  `json:"protocol,omitempty"`
+	Connection    string  `json:"connection,omitempty"`
+	ReusedLatency float64 `json:"reused_latency_seconds,omitempty"`
+
+	Body     *Body     `json:"body,omitempty"`
+	Transfer *Transfer `json:"transfer,omitempty"`
+
+	// Steps are the timed steps of probes that do more than one exchange.
+	Steps []Step `json:"steps,omitempty"`
+
+	// Attempts and Lost count the requests of probes that send several.
+	Attempts int `json:"attempts,omitempty"`
+	Lost     int `json:"lost,omitempty"`
+
+	TLS *TLSInfo `json:"tls,omitempty"`
+
+	// Hops is the path of a path probe, and PathChanged whether it differs
+	// from the previous one.
+	Hops        []Hop `json:"hops,omitempty"`
+	PathChanged bool  `json:"path_changed,omitempty"`
+
+	// MTU is the path MTU discovered by an mtu probe.
+	MTU int `json:"mtu,omitempty"`
+
+	// FailedStep is the step a scripted probe stopped at.
+	FailedStep string `json:"failed_step,omitempty"`
+}
+
+// Step is the latency of one named step of a probe.
+type Step struct {
+	Name    string  `json:"name"`
+	Latency float64 `json:"latency_seconds"`
+}
+
+// Success reports whether the probe completed without a transport or HTTP error.
+func (r Result) Success() bool {
+	return r.Status == "success"
+}
+
+// TLSInfo describes a negotiated TLS connection and the server certificate.
+type TLSInfo struct {
+	Version     string    `json:"version"`
+	CipherSuite string    `json:"cipher_suite"`
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	DNSNames    []string  `json:"dns_names,omitempty"`
+	NotAfter    time.Time `json:"not_after"`
+}
+
+func newTLSInfo(cs tls.ConnectionState) *TLSInfo {
+	info := &TLSInfo{
+		Version:     tls.VersionName(cs.Version),
+		CipherSuite: tls.CipherSuiteName(cs.CipherSuite),
+	}
+	if len(cs.PeerCertificates) > 0 {
+		cert := cs.PeerCertificates[0]
+		info.Subject = cert.Subject.String()
+		info.Issuer = cert.Issuer.String()
+		info.DNSNames = cert.DNSNames
+		info.NotAfter = cert.NotAfter
+	}
+	return info
+}
+
+// ring is a fixed-size buffer holding the most recent results of one target.
+type ring struct {
+	buf  []Result
+	next int
+	full bool
+}
+
+func newRing(size int) *ring {
+	return &ring{buf: make([]Result, size)}
+}
+
+func (r *ring) add(res Result) {
+	r.buf[r.next] = res
+	r.next = (r.next + 1) % len(r.buf)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+func (r *ring) len() int {
+	if r.full {
+		return len(r.buf)
+	}
+	return r.next
+}
+
+// last returns up to n results, newest first. n <= 0 returns everything.
+func (r *ring) last(n int) []Result {
+	size := r.len()
+	if n <= 0 || n > size {
+		n = size
+	}
+
+	out := make([]Result, 0, n)
+	for i := 1; i <= n; i++ {
+		idx := (r.next - i + len(r.buf)) % len(r.buf)
+		out = append(out, r.buf[idx])
+	}
+	return out
+}
+
+type history struct {
+	target Target
+	ring   *ring
+}
+
+// resultStore keeps an in-memory ring buffer of recent results per target.
+type resultStore struct {
+	mu      sync.RWMutex
+	size    int
+	targets map[string]*history
+}
+
+var results = newResultStore(ResultBufferSize)
+
+func newResultStore(size int) *resultStore {
+	return &resultStore{
+		size:    size,
+		targets: make(map[string]*history),
+	}
+}
+
+func (s *resultStore) register(t Target) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if h, ok := s.targets[t.Name]; ok {
+		h.target = t
+		return
+	}
+	s.targets[t.Name] = &history{target: t, ring: newRing(s.size)}
+}
+
+func (s *resultStore) remove(target string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.targets, target)
+}
+
+func (s *resultStore) record(res Result) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// results of a target removed while its probe was in flight are dropped
+	h, ok := s.targets[res.Target]
+	if !ok {
+		return
+	}
+	h.ring.add(res)
+}
+
+// TargetStatus is the current view of a target derived from its recent results.
+type TargetStatus struct {
+	Target       string            `json:"target"`
+	URL          string            `json:"url"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	Interval     string            `json:"interval"`
+	Paused       bool              `json:"paused"`
+	Up           bool              `json:"up"`
+	LastResult   *Result           `json:"last_result,omitempty"`
+	SuccessRatio float64           `json:"success_ratio"`
+	Samples      int               `json:"samples"`
+}
+
+// statuses returns the status of every target matching the label selector,
+// sorted by target.
+func (s *resultStore) statuses(selector map[string]string) []TargetStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make([]TargetStatus, 0, len(s.targets))
+	for _, h := range s.targets {
+		if !matchLabels(h.target.Labels, selector) {
+			continue
+		}
+		out = append(out, h.status())
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Target < out[j].Target })
+	return out
+}
+
+func (s *resultStore) status(target string) (TargetStatus, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	h, ok := s.targets[target]
+	if !ok {
+		return TargetStatus{}, false
+	}
+	return h.status(), true
+}
+
+func (h *history) status() TargetStatus {
+	st := TargetStatus{
+		Target:   h.target.Name,
+		URL:      h.target.URL,
+		Labels:   h.target.Labels,
+		Interval: h.target.Interval.String(),
+		Paused:   h.target.Paused,
+		Samples:  h.ring.len(),
+	}
+
+	all := h.ring.last(0)
+	if len(all) == 0 {
+		return st
+	}
+
+	last := all[0]
+	st.LastResult = &last
+	st.Up = last.Success()
+
+	ok := 0
+	for _, r := range all {
+		if r.Success() {
+			ok++
+		}
+	}
+	st.SuccessRatio = float64(ok) / float64(len(all))
+	return st
+}
+
+// last returns the n most recent results of a target, newest first.
+func (s *resultStore) last(target string, n int) ([]Result, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	h, ok := s.targets[target]
+	if !ok {
+		return nil, false
+	}
+	return h.ring.last(n), true
+}
+
+// between returns the results of a target whose probe started in [from, to],
+// newest first. A zero bound is open.
+func (s *resultStore) between(target string, from, to time.Time) ([]Result, bool) {
+	all, ok := s.last(target, 0)
+	if !ok {
+		return nil, false
+	}
+	return filterTime(all, from, to), true
+}
+
+func filterTime(rs []Result, from, to time.Time) []Result {
+	out := rs[:0:0]
+	for _, r := range rs {
+		if !from.IsZero() && r.Time.Before(from) {
+			continue
+		}
+		if !to.IsZero() && r.Time.After(to) {
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+func matchLabels(labels, selector map[string]string) bool {
+	for k, v := range selector {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Summary aggregates latency percentiles and outcome counts over a set of results.
+type Summary struct {
+	Count        int     `json:"count"`
+	Successes    int     `json:"successes"`
+	Failures     int     `json:"failures"`
+	SuccessRatio float64 `json:"success_ratio"`
+	Min          float64 `json:"min_seconds"`
+	Max          float64 `json:"max_seconds"`
+	Mean         float64 `json:"mean_seconds"`
+	P50          float64 `json:"p50_seconds"`
+	P90          float64 `json:"p90_seconds"`
+	P95          float64 `json:"p95_seconds"`
+	P99          float64 `json:"p99_seconds"`
+}
+
+func summarize(rs []Result) Summary {
+	var sum Summary
+	sum.Count = len(rs)
+	if sum.Count == 0 {
+		return sum
+	}
+
+	latencies := make([]float64, 0, len(rs))
+	total := 0.0
+	for _, r := range rs {
+		if r.Success() {
+			sum.Successes++
+		}
+		latencies = append(latencies, r.Latency)
+		total += r.Latency
+	}
+	sort.Float64s(latencies)
+
+	sum.Failures = sum.Count - sum.Successes
+	sum.SuccessRatio = float64(sum.Successes) / float64(sum.Count)
+	sum.Min = latencies[0]
+	sum.Max = latencies[len(latencies)-1]
+	sum.Mean = total / float64(len(latencies))
+	sum.P50 = percentile(latencies, 0.50)
+	sum.P90 = percentile(latencies, 0.90)
+	sum.P95 = percentile(latencies, 0.95)
+	sum.P99 = percentile(latencies, 0.99)
+	return sum
+}
+
+// percentile uses the nearest-rank method on already sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
+
+// errorBreakdown counts failed results by error reason.
+func errorBreakdown(rs []Result) map[string]int {
+	out := make(map[string]int)
+	for _, r := range rs {
+		if r.ErrorReason == FailureNone {
+			continue
+		}
+		out[r.ErrorReason]++
+	}
+	return out
+}
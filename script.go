@@ -0,0 +1,338 @@
+// Copyright (c) 2025 Dakhil Y.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxScriptBodySize is the largest response body a script step reads for
+// its assertions and extractions.
+const MaxScriptBodySize = 1 << 20
+
+// ScriptOptions turns an http or https target into a transaction of
+// several requests. Step URLs are resolved against the target URL. Values
+// extracted from a response are substituted for ${name} in the URL, headers
+// and body of the steps after it, and cookies are kept from one step to the
+// next. Every probe starts without cookies or variables.
+type ScriptOptions struct {
+	Steps []ScriptStep `yaml:"steps" json:"steps"`
+}
+
+// ScriptStep is one request of a script. It fails unless the status is one
+// of ExpectStatus, or below 400 when that is empty, and the body matches
+// ExpectBody.
+type ScriptStep struct {
+	Name         string             `yaml:"name" json:"name"`
+	Method       string             `yaml:"method" json:"method,omitempty"`
+	URL          string             `yaml:"url" json:"url"`
+	Headers      map[string]string  `yaml:"headers" json:"headers,omitempty"`
+	Body         string             `yaml:"body" json:"body,omitempty"`
+	ExpectStatus []int              `yaml:"expect_status" json:"expect_status,omitempty"`
+	ExpectBody   string             `yaml:"expect_body" json:"expect_body,omitempty"`
+	Extract      map[string]Extract `yaml:"extract" json:"extract,omitempty"`
+}
+
+// Extract takes a variable from a response: a dot-separated JSON path such
+// as data.items.0.id, a header, or the first group of a regular expression
+// matched against the body (the whole match when it has none).
+type Extract struct {
+	JSON   string `yaml:"json" json:"json,omitempty"`
+	Header string `yaml:"header" json:"header,omitempty"`
+	Regex  string `yaml:"regex" json:"regex,omitempty"`
+}
+
+var (
+	scriptVarRE    = regexp.MustCompile(`\$\{([^}]*)\}`)
+	scriptMethodRE = regexp.MustCompile(`^[A-Z]+$`)
+)
+
+func (o *ScriptOptions) validate() error {
+	if o == nil {
+		return nil
+	}
+	if len(o.Steps) == 0 {
+		return errors.New("script must have at least one step")
+	}
+
+	names := map[string]bool{}
+	defined := map[string]bool{}
+	for i, s := range o.Steps {
+		name := s.stepName(i)
+		if !labelNameRE.MatchString(name) {
+			return fmt.Errorf("invalid script step name %q", name)
+		}
+		if names[name] {
+			return fmt.Errorf("duplicate script step name %q", name)
+		}
+		names[name] = true
+
+		if s.Method != "" && !scriptMethodRE.MatchString(s.Method) {
+			return fmt.Errorf("script step %s: invalid method %q", name, s.Method)
+		}
+		if _, err := url.Parse(s.URL); err != nil {
+			return fmt.Errorf("script step %s: invalid url: %w", name, err)
+		}
+		for _, code := range s.ExpectStatus {
+			if code < 100 || code > 599 {
+				return fmt.Errorf("script step %s: invalid expect_status %d", name, code)
+			}
+		}
+		if _, err := regexp.Compile(s.ExpectBody); err != nil {
+			return fmt.Errorf("script step %s: invalid expect_body: %w", name, err)
+		}
+
+		// a step can only use what the steps before it extracted
+		fields := []string{s.URL, s.Body}
+		for k, v := range s.Headers {
+			fields = append(fields, k, v)
+		}
+		for _, f := range fields {
+			for _, m := range scriptVarRE.FindAllStringSubmatch(f, -1) {
+				if !defined[m[1]] {
+					return fmt.Errorf("script step %s: variable %q is not extracted by an earlier step", name, m[1])
+				}
+			}
+		}
+
+		for v, e := range s.Extract {
+			if !labelNameRE.MatchString(v) {
+				return fmt.Errorf("script step %s: invalid variable name %q", name, v)
+			}
+			if err := e.validate(); err != nil {
+				return fmt.Errorf("script step %s: extract %s: %w", name, v, err)
+			}
+			defined[v] = true
+		}
+	}
+	return nil
+}
+
+func (e Extract) validate() error {
+	set := 0
+	for _, v := range []string{e.JSON, e.Header, e.Regex} {
+		if v != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return errors.New("exactly one of json, header and regex must be set")
+	}
+	if _, err := regexp.Compile(e.Regex); err != nil {
+		return fmt.Errorf("invalid regex: %w", err)
+	}
+	return nil
+}
+
+func (s ScriptStep) stepName(i int) string {
+	if s.Name != "" {
+		return s.Name
+	}
+	return "step" + strconv.Itoa(i+1)
+}
+
+type scriptProber struct {
+	target Target
+	base   *url.URL
+	steps  []ScriptStep
+	client *http.Client
+
+	expectBody []*regexp.Regexp
+	regexes    []map[string]*regexp.Regexp
+}
+
+func newScriptProber(t Target) (probeFunc, func()) {
+	base, _ := url.Parse(t.URL)
+	p := &scriptProber{
+		target: t,
+		base:   base,
+		steps:  t.Script.Steps,
+		client: newHTTPClient(t),
+	}
+	for _, s := range p.steps {
+		var expect *regexp.Regexp
+		if s.ExpectBody != "" {
+			expect = regexp.MustCompile(s.ExpectBody)
+		}
+		p.expectBody = append(p.expectBody, expect)
+
+		regexes := map[string]*regexp.Regexp{}
+		for v, e := range s.Extract {
+			if e.Regex != "" {
+				regexes[v] = regexp.MustCompile(e.Regex)
+			}
+		}
+		p.regexes = append(p.regexes, regexes)
+	}
+
+	if p.client == httpClient {
+		return p.probe, func() {}
+	}
+	return p.probe, p.client.CloseIdleConnections
+}
+
+// errExtract is a variable a step could not extract from its response.
+var errExtract = errors.New("cannot extract variable")
+
+// probe runs the steps in order and stops at the first that fails. The
+// latency is that of the whole transaction.
+func (p *scriptProber) probe(ctx context.Context) (Result, error) {
+	res := Result{
+		Target:      p.target.Name,
+		Time:        time.Now(),
+		Status:      "success",
+		ErrorReason: FailureNone,
+	}
+
+	jar, _ := cookiejar.New(nil)
+	client := *p.client
+	client.Jar = jar
+	vars := map[string]string{}
+	expand := func(s string) string {
+		return scriptVarRE.ReplaceAllStringFunc(s, func(m string) string {
+			return vars[m[2:len(m)-1]]
+		})
+	}
+
+	for i, s := range p.steps {
+		start := time.Now()
+		code, status, reason, err := p.step(ctx, &client, i, expand, vars)
+		res.Steps = append(res.Steps, Step{Name: s.stepName(i), Latency: time.Since(start).Seconds()})
+		res.Code = code
+		if status != "" {
+			res.Latency = time.Since(res.Time).Seconds()
+			res.Status = status
+			res.ErrorReason = reason
+			res.FailedStep = s.stepName(i)
+			if status == "transport_error" {
+				return res, err
+			}
+			return res, nil
+		}
+	}
+	res.Latency = time.Since(res.Time).Seconds()
+	return res, nil
+}
+
+// step runs step i and adds its extracted variables to vars. A failure is
+// returned as the status and error reason of the result.
+func (p *scriptProber) step(ctx context.Context, client *http.Client, i int, expand func(string) string,
+	vars map[string]string) (code int, status, reason string, err error) {
+	s := p.steps[i]
+
+	u, err := p.base.Parse(expand(s.URL))
+	if err != nil {
+		return 0, "transport_error", FailureUnknown, err
+	}
+	method := s.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	var body io.Reader
+	if s.Body != "" {
+		body = strings.NewReader(expand(s.Body))
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return 0, "transport_error", FailureUnknown, err
+	}
+	for k, v := range s.Headers {
+		req.Header.Set(expand(k), expand(v))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, "transport_error", classifyHTTPError(p.target, err), err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxScriptBodySize))
+	if err != nil {
+		return resp.StatusCode, "transport_error", classifyTransportError(err), err
+	}
+
+	switch {
+	case len(s.ExpectStatus) > 0 && !slices.Contains(s.ExpectStatus, resp.StatusCode):
+		reason := classifyHTTPStatus(resp.StatusCode)
+		if reason == FailureNone {
+			reason = FailureUnexpectedStatus
+		}
+		return resp.StatusCode, "http_error", reason, nil
+	case len(s.ExpectStatus) == 0 && resp.StatusCode >= 400:
+		return resp.StatusCode, "http_error", classifyHTTPStatus(resp.StatusCode), nil
+	case p.expectBody[i] != nil && !p.expectBody[i].Match(data):
+		return resp.StatusCode, "script_error", FailureResponseMismatch, nil
+	}
+
+	for v, e := range s.Extract {
+		value, err := p.extract(e, p.regexes[i][v], resp.Header, data)
+		if err != nil {
+			return resp.StatusCode, "script_error", FailureExtractFailed, nil
+		}
+		vars[v] = value
+	}
+	return resp.StatusCode, "", "", nil
+}
+
+func (p *scriptProber) extract(e Extract, re *regexp.Regexp, header http.Header, body []byte) (string, error) {
+	switch {
+	case e.Header != "":
+		if v := header.Get(e.Header); v != "" {
+			return v, nil
+		}
+	case re != nil:
+		m := re.FindSubmatch(body)
+		switch {
+		case len(m) > 1:
+			return string(m[1]), nil
+		case len(m) == 1:
+			return string(m[0]), nil
+		}
+	default:
+		var doc any
+		if err := json.Unmarshal(body, &doc); err != nil {
+			return "", err
+		}
+		return jsonPath(doc, e.JSON)
+	}
+	return "", errExtract
+}
+
+// jsonPath follows a dot-separated path of object keys and array indexes
+// and returns the value it ends at, strings unquoted and anything else as
+// JSON.
+func jsonPath(v any, path string) (string, error) {
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]any:
+			next, ok := node[key]
+			if !ok {
+				return "", errExtract
+			}
+			v = next
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return "", errExtract
+			}
+			v = node[i]
+		default:
+			return "", errExtract
+		}
+	}
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	out, err := json.Marshal(v)
+	return string(out), err
+}
@@ -0,0 +1,183 @@
+// Copyright (c) 2025 Dakhil Y.
+package main
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const CertCheckInterval = 30 * time.Second
+
+// listen binds every configured address up front so that a port conflict
+// fails startup instead of being lost in a goroutine.
+func listen(cfg WebConfig) ([]net.Listener, error) {
+	var tlsConfig *tls.Config
+	if cfg.TLS != nil {
+		reloader, err := newCertReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: reloader.getCertificate,
+		}
+	}
+
+	var listeners []net.Listener
+	for _, addr := range cfg.Listen {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+		if tlsConfig != nil {
+			l = tls.NewListener(l, tlsConfig)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// serve runs handler on every listener and returns the first serve error.
+func serve(listeners []net.Listener, handler http.Handler) error {
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		srv := &http.Server{
+			Handler:           handler,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			errs <- fmt.Errorf("serve %s: %w", l.Addr(), srv.Serve(l))
+		}()
+	}
+	return <-errs
+}
+
+// certReloader serves a certificate pair from disk and picks up renewed
+// files without a restart.
+type certReloader struct {
+	certFile, keyFile string
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) reload() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("load certificate: %w", err)
+	}
+
+	r.cert = &cert
+	r.modTime = modTime
+	return nil
+}
+
+func (r *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, f := range []string{r.certFile, r.keyFile} {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return latest, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// getCertificate checks the files at most once per CertCheckInterval. A
+// failed reload keeps serving the previous certificate.
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.lastCheck) < CertCheckInterval {
+		return r.cert, nil
+	}
+	r.lastCheck = time.Now()
+
+	modTime, err := r.latestModTime()
+	if err != nil || !modTime.After(r.modTime) {
+		return r.cert, nil
+	}
+	if err := r.reload(); err != nil {
+		fmt.Printf("Error reloading certificate: %v\n", err)
+	}
+	return r.cert, nil
+}
+
+// requireAuth accepts either the configured bearer token or basic auth
+// credentials.
+func requireAuth(cfg AuthConfig, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authorized(cfg, r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if cfg.Username != "" {
+			w.Header().Add("WWW-Authenticate", `Basic realm="netpulse"`)
+		}
+		if cfg.BearerToken != "" {
+			w.Header().Add("WWW-Authenticate", `Bearer realm="netpulse"`)
+		}
+		writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+	})
+}
+
+func authorized(cfg AuthConfig, r *http.Request) bool {
+	if cfg.BearerToken != "" {
+		if got, ok := bearerToken(r); ok && secureEqual(got, cfg.BearerToken) {
+			return true
+		}
+	}
+	if cfg.Username != "" {
+		user, pass, ok := r.BasicAuth()
+		// evaluate both to keep timing independent of which one is wrong
+		userOK := secureEqual(user, cfg.Username)
+		passOK := secureEqual(pass, cfg.Password)
+		if ok && userOK && passOK {
+			return true
+		}
+	}
+	return false
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	h := r.Header.Get("Authorization")
+	if len(h) < 7 || !strings.EqualFold(h[:7], "Bearer ") {
+		return "", false
+	}
+	return h[7:], true
+}
+
+func secureEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
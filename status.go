@@ -0,0 +1,131 @@
+// Copyright (c) 2025 Dakhil Y.
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	StatusRefreshInterval = 2 * time.Second
+	SparklinePoints       = 60
+	RecentFailures        = 5
+)
+
+//go:embed web
+var webFS embed.FS
+
+// StatusEntry is one row of the status page.
+type StatusEntry struct {
+	Target   string            `json:"target"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Up       bool              `json:"up"`
+	Known    bool              `json:"known"`
+	Uptime   float64           `json:"uptime"`
+	Latency  float64           `json:"latency_seconds"`
+	History  []float64         `json:"history"`
+	Failures []StatusFailure   `json:"failures"`
+}
+
+// StatusFailure is a recent failed probe shown on the status page.
+type StatusFailure struct {
+	Time   time.Time `json:"time"`
+	Reason string    `json:"reason"`
+}
+
+// statusPage serves the embedded status page and its event stream. In public
+// mode target URLs are reduced to host and path and labels are hidden.
+type statusPage struct {
+	public bool
+}
+
+func registerStatusPage(mux *http.ServeMux, public bool) {
+	p := &statusPage{public: public}
+	static, _ := fs.Sub(webFS, "web")
+
+	mux.Handle("GET /status/", http.StripPrefix("/status/", http.FileServerFS(static)))
+	mux.HandleFunc("GET /status/events", p.handleEvents)
+	mux.Handle("GET /{$}", http.RedirectHandler("/status/", http.StatusFound))
+}
+
+func (p *statusPage) snapshot() []StatusEntry {
+	statuses := results.statuses(nil)
+	out := make([]StatusEntry, 0, len(statuses))
+
+	for _, st := range statuses {
+		e := StatusEntry{
+			Target:   st.Target,
+			Labels:   st.Labels,
+			Up:       st.Up,
+			Known:    st.LastResult != nil,
+			Uptime:   st.SuccessRatio,
+			Failures: []StatusFailure{},
+		}
+		if st.LastResult != nil {
+			e.Latency = st.LastResult.Latency
+		}
+
+		recent, _ := results.last(st.Target, SparklinePoints)
+		e.History = make([]float64, len(recent))
+		for i, r := range recent {
+			// oldest first so the sparkline reads left to right
+			e.History[len(recent)-1-i] = r.Latency
+			if !r.Success() && len(e.Failures) < RecentFailures {
+				e.Failures = append(e.Failures, StatusFailure{Time: r.Time, Reason: r.ErrorReason})
+			}
+		}
+
+		if p.public {
+			e.Target = publicName(st.Target)
+			e.Labels = nil
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+// publicName drops the scheme, credentials, port and query from a target URL.
+func publicName(target string) string {
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" {
+		return target
+	}
+	return strings.TrimSuffix(u.Hostname()+u.Path, "/")
+}
+
+func (p *statusPage) handleEvents(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	ticker := time.NewTicker(StatusRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		data, err := json.Marshal(p.snapshot())
+		if err != nil {
+			return
+		}
+		if _, err := fmt.Fprintf(w, "event: status\ndata: %s\n\n", data); err != nil {
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
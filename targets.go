@@ -0,0 +1,392 @@
+// Copyright (c) 2025 Dakhil Y.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+const (
+	SourceConfig = "config"
+	SourceAPI    = "api"
+)
+
+var (
+	ErrTargetExists   = errors.New("target already exists")
+	ErrTargetNotFound = errors.New("target not found")
+	ErrTargetReadOnly = errors.New("target is not managed by the API")
+	ErrStateSave      = errors.New("save state")
+)
+
+type job struct {
+	target Target
+	source string
+	cancel context.CancelFunc
+	fanOut bool // expanded targets are running under fanOutSource
+}
+
+// scheduler owns the set of probed targets and runs one prober per active
+// target. Targets created through the admin API and pause state are persisted
+// to the state file.
+type scheduler struct {
+	mu        sync.Mutex
+	jobs      map[string]*job
+	paused    map[string]bool
+	stateFile string
+	naming    NamingConfig
+}
+
+// state is the on-disk form of runtime changes.
+type state struct {
+	Targets []Target `json:"targets"`
+	Paused  []string `json:"paused"`
+}
+
+var targets *scheduler
+
+func newScheduler(stateFile string, naming NamingConfig) *scheduler {
+	return &scheduler{
+		jobs:      make(map[string]*job),
+		paused:    make(map[string]bool),
+		stateFile: stateFile,
+		naming:    naming,
+	}
+}
+
+// load starts the config targets and restores runtime targets and pause
+// state from the state file.
+func (s *scheduler) load(cfg *Config) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var st state
+	data, err := os.ReadFile(s.stateFile)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return fmt.Errorf("read state: %w", err)
+	default:
+		if err := json.Unmarshal(data, &st); err != nil {
+			return fmt.Errorf("parse state %s: %w", s.stateFile, err)
+		}
+	}
+
+	// pause state is kept by name so it also applies to targets that are
+	// discovered later
+	for _, name := range st.Paused {
+		s.paused[name] = true
+	}
+
+	for _, t := range cfg.Targets {
+		s.start(t, SourceConfig)
+	}
+
+	for _, t := range st.Targets {
+		t.applyDefaults(s.naming)
+		if err := errors.Join(t.validate(), t.validateLabelKeys(targetLabels)); err != nil {
+			fmt.Printf("Skipping invalid target %s from state: %v\n", t.Name, err)
+			continue
+		}
+		if _, ok := s.jobs[t.Name]; ok {
+			fmt.Printf("Skipping target %s from state: already in config\n", t.Name)
+			continue
+		}
+		s.start(t, SourceAPI)
+	}
+	return nil
+}
+
+// start registers a target and launches its prober unless it is paused.
+// Callers hold s.mu.
+func (s *scheduler) start(t Target, source string) {
+	t.Paused = t.Paused || s.paused[t.Name]
+	j := &job{target: t, source: source}
+	s.jobs[t.Name] = j
+	s.restart(j)
+}
+
+func (s *scheduler) restart(j *job) {
+	if j.cancel != nil {
+		j.cancel()
+		j.cancel = nil
+	}
+	if j.fanOut {
+		s.replace(fanOutSource(j.target.Name), nil)
+		j.fanOut = false
+	}
+
+	// a fan-out target only has results under its expanded targets
+	if j.target.fansOut() {
+		results.remove(j.target.Name)
+	} else {
+		results.register(s.redacted(j.target))
+	}
+
+	if j.target.Paused {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	j.cancel = cancel
+	if j.target.fansOut() {
+		j.fanOut = true
+		go s.runFanOut(ctx, j.target)
+		return
+	}
+	go startIndividualProber(ctx, j.target)
+}
+
+func (s *scheduler) list() []Target {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]Target, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		out = append(out, j.target)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func (s *scheduler) get(key string) (Target, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[key]
+	if !ok {
+		return Target{}, false
+	}
+	return j.target, true
+}
+
+// add starts a new runtime target and returns it with defaults applied. A
+// target created paused is recorded as paused so it stays paused on restart.
+func (s *scheduler) add(t Target) (Target, error) {
+	t.applyDefaults(s.naming)
+	if err := t.validate(); err != nil {
+		return t, err
+	}
+	if err := t.validateLabelKeys(targetLabels); err != nil {
+		return t, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.jobs[t.Name]; ok {
+		return t, ErrTargetExists
+	}
+	if t.Paused {
+		s.paused[t.Name] = true
+	}
+	s.start(t, SourceAPI)
+	return t, s.save()
+}
+
+// update replaces the settings of a runtime target, keeping its pause state.
+// Pause state is only changed through setPaused.
+func (s *scheduler) update(key string, t Target) error {
+	if t.Paused {
+		return errors.New("paused cannot be set on update, use the pause and resume endpoints")
+	}
+	if t.Name == "" {
+		t.Name = key
+	}
+	if t.Name != key {
+		return fmt.Errorf("name %q does not match target %q", t.Name, key)
+	}
+	t.applyDefaults(s.naming)
+	if err := t.validate(); err != nil {
+		return err
+	}
+	if err := t.validateLabelKeys(targetLabels); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[key]
+	if !ok {
+		return ErrTargetNotFound
+	}
+	if j.source != SourceAPI {
+		return ErrTargetReadOnly
+	}
+
+	t.Paused = j.target.Paused
+	j.target = t
+	deleteSeries(key)
+	s.restart(j)
+	return s.save()
+}
+
+func (s *scheduler) setPaused(key string, paused bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[key]
+	if !ok {
+		return ErrTargetNotFound
+	}
+	if j.target.Paused == paused {
+		return nil
+	}
+
+	j.target.Paused = paused
+	if paused {
+		s.paused[key] = true
+	} else {
+		delete(s.paused, key)
+	}
+	s.restart(j)
+	return s.save()
+}
+
+func (s *scheduler) remove(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[key]
+	if !ok {
+		return ErrTargetNotFound
+	}
+	if j.source != SourceAPI {
+		return ErrTargetReadOnly
+	}
+
+	s.stop(key)
+	delete(s.paused, key)
+	return s.save()
+}
+
+// sync replaces the targets owned by a discovery source with ts. Targets
+// whose name is already taken by another source are skipped.
+func (s *scheduler) sync(source string, ts []Target) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.replace(source, ts)
+}
+
+// replace implements sync. Callers hold s.mu.
+func (s *scheduler) replace(source string, ts []Target) {
+	seen := make(map[string]bool, len(ts))
+	for _, t := range ts {
+		if seen[t.Name] {
+			fmt.Printf("Skipping duplicate target %s from %s\n", t.Name, source)
+			continue
+		}
+		seen[t.Name] = true
+
+		j, ok := s.jobs[t.Name]
+		switch {
+		case !ok:
+			fmt.Printf("Discovered target %s from %s\n", t.Name, source)
+			s.start(t, source)
+		case j.source != source:
+			fmt.Printf("Skipping target %s from %s: already defined by %s\n", t.Name, source, j.source)
+		case !sameTarget(j.target, t):
+			t.Paused = j.target.Paused
+			j.target = t
+			deleteSeries(t.Name)
+			s.restart(j)
+		}
+	}
+
+	for name, j := range s.jobs {
+		if j.source != source || seen[name] {
+			continue
+		}
+		fmt.Printf("Removing target %s, no longer reported by %s\n", name, source)
+		s.stop(name)
+	}
+}
+
+// stop cancels a target's prober and forgets its results and series.
+// Callers hold s.mu.
+func (s *scheduler) stop(name string) {
+	if j, ok := s.jobs[name]; ok {
+		if j.cancel != nil {
+			j.cancel()
+		}
+		if j.fanOut {
+			s.replace(fanOutSource(name), nil)
+		}
+	}
+	delete(s.jobs, name)
+	results.remove(name)
+	deleteSeries(name)
+	limiter.release(name)
+}
+
+// redacted returns t with credentials and redacted query parameters removed
+// from its URL, for targets shown by the API.
+func (s *scheduler) redacted(t Target) Target {
+	t.URL = s.naming.redact(t.URL)
+	return t
+}
+
+// sameTarget reports whether two targets are probed identically, ignoring
+// pause state.
+func sameTarget(a, b Target) bool {
+	a.Paused, b.Paused = false, false
+	return reflect.DeepEqual(a, b)
+}
+
+// save persists the runtime targets and pause state. Callers hold s.mu.
+func (s *scheduler) save() error {
+	if err := s.writeState(); err != nil {
+		return fmt.Errorf("%w: %v", ErrStateSave, err)
+	}
+	return nil
+}
+
+// writeState replaces the state file atomically. Target URLs are stored as
+// given, credentials included, since they are needed to probe; the file is
+// only readable by its owner.
+func (s *scheduler) writeState() error {
+	st := state{Targets: []Target{}, Paused: []string{}}
+	for _, j := range s.jobs {
+		if j.source == SourceAPI {
+			t := j.target
+			t.Paused = false
+			st.Targets = append(st.Targets, t)
+		}
+	}
+	for name := range s.paused {
+		st.Paused = append(st.Paused, name)
+	}
+	sort.Slice(st.Targets, func(i, j int) bool { return st.Targets[i].Name < st.Targets[j].Name })
+	sort.Strings(st.Paused)
+
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.stateFile), ".netpulse-state-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.stateFile); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,346 @@
+// Copyright (c) 2025 Dakhil Y.
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	DirectionDownload = "download"
+	DirectionUpload   = "upload"
+
+	DefaultThroughputSize     = 10 << 20
+	MaxThroughputSize         = 1 << 30
+	DefaultThroughputTimeout  = 30 * time.Second
+	DefaultThroughputInterval = 5 * time.Minute
+
+	// ThroughputPath is where netpulse serves and accepts payloads when
+	// web.throughput_endpoint is set.
+	ThroughputPath = "/throughput"
+)
+
+// ThroughputOptions turns an http or https target into a throughput test
+// that transfers Size bytes in one direction within Timeout. Downloads ask
+// for the size in a size query parameter, which the netpulse endpoint
+// honours, and read at most that many bytes of the body. Uploads post a
+// body of Size bytes. A bearer token, or Username with a password, is sent
+// to endpoints behind web.auth. Like database passwords, secrets are read
+// from a file or an environment variable on every probe and may not appear
+// in the config or the URL.
+type ThroughputOptions struct {
+	Direction       string   `yaml:"direction" json:"direction,omitempty"`
+	Size            int64    `yaml:"size" json:"size,omitempty"`
+	Timeout         Duration `yaml:"timeout" json:"timeout,omitempty"`
+	Username        string   `yaml:"username" json:"username,omitempty"`
+	PasswordFile    string   `yaml:"password_file" json:"password_file,omitempty"`
+	PasswordEnv     string   `yaml:"password_env" json:"password_env,omitempty"`
+	BearerTokenFile string   `yaml:"bearer_token_file" json:"bearer_token_file,omitempty"`
+	BearerTokenEnv  string   `yaml:"bearer_token_env" json:"bearer_token_env,omitempty"`
+}
+
+func (o *ThroughputOptions) validate(t *Target) error {
+	if o == nil {
+		return nil
+	}
+	if t.Script != nil {
+		return errors.New("throughput options cannot be combined with a script")
+	}
+	switch o.Direction {
+	case "", DirectionDownload, DirectionUpload:
+	default:
+		return fmt.Errorf("throughput.direction must be %q or %q", DirectionDownload, DirectionUpload)
+	}
+	if o.Size < 0 || o.Size > MaxThroughputSize {
+		return fmt.Errorf("throughput.size must be between 1 and %d", MaxThroughputSize)
+	}
+	if o.Timeout < 0 {
+		return errors.New("throughput.timeout must not be negative")
+	}
+	u, err := url.Parse(t.URL)
+	if err != nil {
+		return err
+	}
+	if _, ok := u.User.Password(); ok {
+		return fmt.Errorf("invalid url %q: set the password with throughput.password_file or throughput.password_env",
+			u.Redacted())
+	}
+	if o.PasswordFile != "" && o.PasswordEnv != "" {
+		return errors.New("throughput.password_file and throughput.password_env are mutually exclusive")
+	}
+	if o.BearerTokenFile != "" && o.BearerTokenEnv != "" {
+		return errors.New("throughput.bearer_token_file and throughput.bearer_token_env are mutually exclusive")
+	}
+	hasPassword := o.PasswordFile != "" || o.PasswordEnv != ""
+	switch {
+	case o.Username != "" && !hasPassword:
+		return errors.New("throughput.username requires throughput.password_file or throughput.password_env")
+	case o.Username == "" && hasPassword:
+		return errors.New("throughput.password_file and throughput.password_env require throughput.username")
+	case o.Username != "" && (o.BearerTokenFile != "" || o.BearerTokenEnv != ""):
+		return errors.New("a throughput bearer token cannot be combined with a username")
+	}
+	return nil
+}
+
+// authorize adds the configured credentials to req.
+func (o ThroughputOptions) authorize(req *http.Request) error {
+	token, err := readSecret(o.BearerTokenFile, o.BearerTokenEnv)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	}
+	if o.Username != "" {
+		password, err := readSecret(o.PasswordFile, o.PasswordEnv)
+		if err != nil {
+			return err
+		}
+		req.SetBasicAuth(o.Username, password)
+	}
+	return nil
+}
+
+// Transfer is the outcome of a throughput probe. Seconds is the time the
+// payload took once the connection was ready, which BitsPerSecond is
+// based on.
+type Transfer struct {
+	Direction     string  `json:"direction"`
+	Bytes         int64   `json:"bytes"`
+	Seconds       float64 `json:"seconds"`
+	BitsPerSecond float64 `json:"bits_per_second"`
+}
+
+type throughputProber struct {
+	target    Target
+	url       string
+	direction string
+	size      int64
+	opts      ThroughputOptions
+	client    *http.Client
+}
+
+func newThroughputProber(t Target) (probeFunc, func()) {
+	opts := *t.Throughput
+	p := &throughputProber{
+		target:    t,
+		url:       t.URL,
+		direction: opts.Direction,
+		size:      opts.Size,
+		opts:      opts,
+	}
+	if p.direction == "" {
+		p.direction = DirectionDownload
+	}
+	if p.size == 0 {
+		p.size = DefaultThroughputSize
+	}
+	if p.direction == DirectionDownload {
+		u, _ := url.Parse(t.URL)
+		q := u.Query()
+		q.Set("size", strconv.FormatInt(p.size, 10))
+		u.RawQuery = q.Encode()
+		p.url = u.String()
+	}
+
+	base := newHTTPClient(t)
+	client := *base
+	client.Timeout = time.Duration(opts.Timeout)
+	if client.Timeout == 0 {
+		client.Timeout = DefaultThroughputTimeout
+	}
+	p.client = &client
+
+	if base == httpClient {
+		return p.probe, func() {}
+	}
+	return p.probe, base.CloseIdleConnections
+}
+
+// probe transfers the payload. The latency covers the whole request, while
+// the throughput leaves out connection setup: an upload is timed from the
+// connection being ready to the first byte of the response, which the
+// netpulse endpoint only sends once it has read everything, and a download
+// from the first byte of the response to the end of the body.
+func (p *throughputProber) probe(ctx context.Context) (Result, error) {
+	res := Result{
+		Target:      p.target.Name,
+		Time:        time.Now(),
+		Status:      "success",
+		ErrorReason: FailureNone,
+	}
+
+	var mu sync.Mutex
+	var gotConn, firstByte time.Time
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(httptrace.GotConnInfo) {
+			mu.Lock()
+			gotConn = time.Now()
+			mu.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			mu.Lock()
+			firstByte = time.Now()
+			mu.Unlock()
+		},
+	})
+
+	method, body := http.MethodGet, io.Reader(nil)
+	if p.direction == DirectionUpload {
+		method, body = http.MethodPost, &payload{n: p.size}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, p.url, body)
+	if err != nil {
+		res.Status = "transport_error"
+		res.ErrorReason = FailureUnknown
+		return res, err
+	}
+	if p.direction == DirectionUpload {
+		req.ContentLength = p.size
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(&payload{n: p.size}), nil
+		}
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	// compression would measure the server's CPU rather than the link
+	req.Header.Set("Accept-Encoding", "identity")
+	if err := p.opts.authorize(req); err != nil {
+		res.Latency = time.Since(res.Time).Seconds()
+		res.Status = "transport_error"
+		res.ErrorReason = FailureCredentials
+		return res, err
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		res.Latency = time.Since(res.Time).Seconds()
+		res.Status = "transport_error"
+		res.ErrorReason = classifyHTTPError(p.target, err)
+		return res, err
+	}
+	defer resp.Body.Close()
+	res.Code = resp.StatusCode
+	res.Protocol = responseProtocol(resp)
+
+	limit := p.size
+	if p.direction == DirectionUpload {
+		limit = p.target.maxBodySize()
+	}
+	n, err := io.Copy(io.Discard, io.LimitReader(resp.Body, limit))
+	end := time.Now()
+	res.Latency = end.Sub(res.Time).Seconds()
+	if err == nil && resp.ContentLength >= 0 && resp.ContentLength <= limit && n != resp.ContentLength {
+		err = fmt.Errorf("%w: got %d bytes, expected %d", errContentLength, n, resp.ContentLength)
+	}
+	switch {
+	case err != nil:
+		res.Status = "transport_error"
+		res.ErrorReason = classifyBodyError(err)
+		return res, err
+	case resp.StatusCode >= 400:
+		res.Status = "http_error"
+		res.ErrorReason = classifyHTTPStatus(resp.StatusCode)
+		return res, nil
+	}
+
+	mu.Lock()
+	from, to := firstByte, end
+	if p.direction == DirectionUpload {
+		from, to, n = gotConn, firstByte, p.size
+	}
+	mu.Unlock()
+	res.Transfer = &Transfer{Direction: p.direction, Bytes: n}
+	if d := to.Sub(from).Seconds(); !from.IsZero() && d > 0 {
+		res.Transfer.Seconds = d
+		res.Transfer.BitsPerSecond = float64(n*8) / d
+	}
+	return res, nil
+}
+
+// payloadBlock is repeated to make up payloads. It is random so that
+// compression along the way cannot shrink them.
+var payloadBlock = func() []byte {
+	b := make([]byte, 64<<10)
+	rand.Read(b)
+	return b
+}()
+
+// payload reads n bytes of payloadBlock over and over.
+type payload struct {
+	n, off int64
+}
+
+func (p *payload) Read(b []byte) (int, error) {
+	if p.n == 0 {
+		return 0, io.EOF
+	}
+	if int64(len(b)) > p.n {
+		b = b[:p.n]
+	}
+	k := copy(b, payloadBlock[p.off:])
+	p.off = (p.off + int64(k)) % int64(len(payloadBlock))
+	p.n -= int64(k)
+	return k, nil
+}
+
+// registerThroughputEndpoint adds the endpoint other netpulse instances run
+// throughput probes against: GET sends the number of bytes in the size
+// query parameter, POST reads the body and reports its size. It requires
+// web.auth when that is configured.
+func registerThroughputEndpoint(mux *http.ServeMux, cfg AuthConfig) {
+	handle := func(pattern string, h http.HandlerFunc) {
+		if cfg.Enabled() {
+			mux.Handle(pattern, requireAuth(cfg, h))
+			return
+		}
+		mux.Handle(pattern, h)
+	}
+	handle("GET "+ThroughputPath, handleThroughputDownload)
+	handle("POST "+ThroughputPath, handleThroughputUpload)
+}
+
+func handleThroughputDownload(w http.ResponseWriter, r *http.Request) {
+	size := int64(DefaultThroughputSize)
+	if s := r.URL.Query().Get("size"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n < 0 || n > MaxThroughputSize {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("size must be between 0 and %d", MaxThroughputSize))
+			return
+		}
+		size = n
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.Header().Set("Cache-Control", "no-store")
+	io.Copy(w, &payload{n: size})
+}
+
+func handleThroughputUpload(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	n, err := io.Copy(io.Discard, http.MaxBytesReader(w, r.Body, MaxThroughputSize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, err)
+			return
+		}
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"bytes":   n,
+		"seconds": time.Since(start).Seconds(),
+	})
+}
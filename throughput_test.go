@@ -0,0 +1,210 @@
+// Copyright (c) 2025 Dakhil Y.
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Secrets the tests hand to netpulse, which must never show up in state or
+// error messages.
+const (
+	testPassword = "pa55word"
+	testToken    = "t0ken"
+)
+
+func startThroughputEndpoint(t *testing.T) string {
+	t.Helper()
+	mux := http.NewServeMux()
+	registerThroughputEndpoint(mux, AuthConfig{Username: "probe", Password: testPassword, BearerToken: testToken})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv.URL + ThroughputPath
+}
+
+func TestThroughputProbeCredentials(t *testing.T) {
+	t.Setenv("NETPULSE_TEST_TOKEN", testToken)
+	t.Setenv("NETPULSE_TEST_WRONG_TOKEN", "wrong")
+	dir := t.TempDir()
+	passwordFile := filepath.Join(dir, "password")
+	if err := os.WriteFile(passwordFile, []byte(testPassword+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	url := startThroughputEndpoint(t)
+
+	tests := []struct {
+		name       string
+		opts       ThroughputOptions
+		wantStatus string
+		wantReason string
+	}{
+		{
+			name:       "bearer token env",
+			opts:       ThroughputOptions{BearerTokenEnv: "NETPULSE_TEST_TOKEN"},
+			wantStatus: "success",
+			wantReason: FailureNone,
+		},
+		{
+			name:       "basic auth upload",
+			opts:       ThroughputOptions{Direction: DirectionUpload, Username: "probe", PasswordFile: passwordFile},
+			wantStatus: "success",
+			wantReason: FailureNone,
+		},
+		{
+			name:       "no credentials",
+			wantStatus: "http_error",
+			wantReason: FailureHTTP4xx,
+		},
+		{
+			name:       "wrong token",
+			opts:       ThroughputOptions{BearerTokenEnv: "NETPULSE_TEST_WRONG_TOKEN"},
+			wantStatus: "http_error",
+			wantReason: FailureHTTP4xx,
+		},
+		{
+			name:       "unset token env",
+			opts:       ThroughputOptions{BearerTokenEnv: "NETPULSE_TEST_UNSET"},
+			wantStatus: "transport_error",
+			wantReason: FailureCredentials,
+		},
+		{
+			name:       "missing password file",
+			opts:       ThroughputOptions{Username: "probe", PasswordFile: filepath.Join(dir, "missing")},
+			wantStatus: "transport_error",
+			wantReason: FailureCredentials,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.Size = 1 << 10
+			target := Target{URL: url, Interval: Duration(time.Second), Throughput: &tt.opts}
+			target.Name = target.URL
+			if err := target.validate(); err != nil {
+				t.Fatal(err)
+			}
+			run, closeProber := newThroughputProber(target)
+			defer closeProber()
+
+			res, err := run(context.Background())
+			if res.Status != tt.wantStatus || res.ErrorReason != tt.wantReason {
+				t.Fatalf("result = %s/%s (%v), want %s/%s", res.Status, res.ErrorReason, err,
+					tt.wantStatus, tt.wantReason)
+			}
+			if res.Latency <= 0 {
+				t.Errorf("latency = %v, want it set", res.Latency)
+			}
+			if err != nil && (strings.Contains(err.Error(), testPassword) || strings.Contains(err.Error(), testToken)) {
+				t.Errorf("error %q shows a secret", err)
+			}
+		})
+	}
+}
+
+func TestThroughputOptionsValidate(t *testing.T) {
+	tests := []struct {
+		url     string
+		opts    ThroughputOptions
+		wantErr string
+	}{
+		{url: "https://probe:" + testPassword + "@example.com/throughput", wantErr: "throughput.password_file"},
+		{
+			url:     "https://example.com/throughput",
+			opts:    ThroughputOptions{Username: "probe", PasswordFile: "/run/secrets/p", PasswordEnv: "P"},
+			wantErr: "mutually exclusive",
+		},
+		{
+			url:     "https://example.com/throughput",
+			opts:    ThroughputOptions{BearerTokenFile: "/run/secrets/t", BearerTokenEnv: "T"},
+			wantErr: "mutually exclusive",
+		},
+		{
+			url:     "https://example.com/throughput",
+			opts:    ThroughputOptions{Username: "probe"},
+			wantErr: "requires throughput.password_file",
+		},
+		{
+			url:     "https://example.com/throughput",
+			opts:    ThroughputOptions{PasswordEnv: "P"},
+			wantErr: "require throughput.username",
+		},
+		{
+			url:     "https://example.com/throughput",
+			opts:    ThroughputOptions{Username: "probe", PasswordEnv: "P", BearerTokenEnv: "T"},
+			wantErr: "cannot be combined",
+		},
+		{url: "https://example.com/throughput", opts: ThroughputOptions{Username: "probe", PasswordEnv: "P"}},
+	}
+	for _, tt := range tests {
+		t.Run(cmp.Or(tt.wantErr, "valid"), func(t *testing.T) {
+			target := Target{URL: tt.url, Throughput: &tt.opts}
+			err := tt.opts.validate(&target)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("validate = %v, want nil", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("validate = %v, want an error containing %q", err, tt.wantErr)
+			}
+			if err != nil && strings.Contains(err.Error(), testPassword) {
+				t.Errorf("error %q shows the password", err)
+			}
+		})
+	}
+}
+
+// TestThroughputSecretsNotStored creates throughput targets through the
+// admin API and checks that no secret reaches the state file or a response.
+func TestThroughputSecretsNotStored(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	saved := targets
+	targets = newScheduler(stateFile, NamingConfig{})
+	t.Cleanup(func() { targets = saved })
+
+	create := func(body string) *httptest.ResponseRecorder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handleCreateTarget(w, httptest.NewRequest(http.MethodPost, "/api/v1/targets", strings.NewReader(body)))
+		return w
+	}
+
+	// paused, so that nothing is probed
+	w := create(`{"url": "https://netpulse.example.com/throughput", "interval": "5m", "paused": true,
+		"throughput": {"bearer_token_env": "NETPULSE_TOKEN"}}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create = %d %s, want %d", w.Code, w.Body, http.StatusCreated)
+	}
+
+	rejected := []string{
+		`{"url": "https://netpulse.example.com/a", "throughput": {"bearer_token": "` + testToken + `"}}`,
+		`{"url": "https://netpulse.example.com/b", "throughput": {"username": "probe", "password": "` +
+			testPassword + `"}}`,
+		`{"url": "https://probe:` + testPassword + `@netpulse.example.com/c", "throughput": {}}`,
+	}
+	for _, body := range rejected {
+		w := create(body)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("create %s = %d, want %d", body, w.Code, http.StatusBadRequest)
+		}
+		if strings.Contains(w.Body.String(), testPassword) || strings.Contains(w.Body.String(), testToken) {
+			t.Errorf("response %s shows a secret", w.Body)
+		}
+	}
+
+	state, err := os.ReadFile(stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(state, []byte(`"bearer_token_env": "NETPULSE_TOKEN"`)) {
+		t.Errorf("state %s does not keep the token variable", state)
+	}
+	if bytes.Contains(state, []byte(testPassword)) || bytes.Contains(state, []byte(testToken)) {
+		t.Errorf("state %s shows a secret", state)
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright (c) 2025 Dakhil Y.
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"time"
+)
+
+const (
+	DefaultUDPAttempts = 3
+	DefaultUDPTimeout  = time.Second
+	MaxUDPAttempts     = 100
+
+	// UDPReadBufferSize is the largest reply read, the maximum UDP payload.
+	UDPReadBufferSize = 65535
+)
+
+// UDPOptions configures a udp://host:port target. Each probe sends the
+// payload Attempts times, one at a time, and waits up to Timeout for a reply
+// matching Expect, or for any reply when Expect is empty.
+type UDPOptions struct {
+	Payload    string   `yaml:"payload" json:"payload,omitempty"`
+	PayloadHex string   `yaml:"payload_hex" json:"payload_hex,omitempty"`
+	Expect     string   `yaml:"expect" json:"expect,omitempty"`
+	Attempts   int      `yaml:"attempts" json:"attempts,omitempty"`
+	Timeout    Duration `yaml:"timeout" json:"timeout,omitempty"`
+}
+
+func (o *UDPOptions) validate(t *Target) error {
+	u, err := url.Parse(t.URL)
+	if err != nil {
+		return err
+	}
+	if u.Port() == "" {
+		return fmt.Errorf("invalid url %q: udp url must include a port", t.URL)
+	}
+	if o == nil {
+		return nil
+	}
+	if o.Payload != "" && o.PayloadHex != "" {
+		return errors.New("udp.payload and udp.payload_hex are mutually exclusive")
+	}
+	if _, err := hex.DecodeString(o.PayloadHex); err != nil {
+		return fmt.Errorf("invalid udp.payload_hex: %w", err)
+	}
+	if _, err := regexp.Compile(o.Expect); err != nil {
+		return fmt.Errorf("invalid udp.expect: %w", err)
+	}
+	if o.Attempts < 0 || o.Attempts > MaxUDPAttempts {
+		return fmt.Errorf("udp.attempts must be between 1 and %d", MaxUDPAttempts)
+	}
+	if o.Timeout < 0 {
+		return errors.New("udp.timeout must not be negative")
+	}
+	return nil
+}
+
+type udpProber struct {
+	target   Target
+	addr     string
+	network  string
+	payload  []byte
+	expect   *regexp.Regexp
+	attempts int
+	timeout  time.Duration
+}
+
+func newUDPProber(t Target) (probeFunc, func()) {
+	var opts UDPOptions
+	if t.UDP != nil {
+		opts = *t.UDP
+	}
+
+	u, _ := url.Parse(t.URL)
+	p := &udpProber{
+		target:   t,
+		addr:     u.Host,
+		network:  dialNetwork("udp", t.IPVersion),
+		payload:  []byte(opts.Payload),
+		attempts: opts.Attempts,
+		timeout:  time.Duration(opts.Timeout),
+	}
+	if t.Address != "" {
+		p.addr = t.Address
+	}
+	if opts.PayloadHex != "" {
+		p.payload, _ = hex.DecodeString(opts.PayloadHex)
+	}
+	if opts.Expect != "" {
+		p.expect = regexp.MustCompile(opts.Expect)
+	}
+	if p.attempts == 0 {
+		p.attempts = DefaultUDPAttempts
+	}
+	if p.timeout == 0 {
+		p.timeout = DefaultUDPTimeout
+	}
+	return p.probe, func() {}
+}
+
+// probe reports the mean round trip time of the answered attempts as its
+// latency. It fails only when no attempt was answered.
+func (p *udpProber) probe(ctx context.Context) (Result, error) {
+	res := Result{
+		Target:      p.target.Name,
+		Time:        time.Now(),
+		Status:      "success",
+		ErrorReason: FailureNone,
+	}
+
+	dialCtx, cancel := context.WithTimeout(ctx, p.timeout)
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(dialCtx, p.network, p.addr)
+	cancel()
+	if err != nil {
+		res.Latency = time.Since(res.Time).Seconds()
+		res.Status = "transport_error"
+		res.ErrorReason = classifyTransportError(err)
+		return res, err
+	}
+	defer conn.Close()
+
+	var rtt time.Duration
+	var lastErr error
+	mismatched := false
+	buf := make([]byte, UDPReadBufferSize)
+
+	for range p.attempts {
+		d, err := p.attempt(conn, buf)
+		res.Attempts++
+		switch {
+		case err == nil:
+			rtt += d
+		case errors.Is(err, errResponseMismatch):
+			mismatched = true
+			res.Lost++
+		default:
+			lastErr = err
+			res.Lost++
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	if answered := res.Attempts - res.Lost; answered > 0 {
+		res.Latency = (rtt / time.Duration(answered)).Seconds()
+		return res, nil
+	}
+
+	res.Latency = time.Since(res.Time).Seconds()
+	if mismatched {
+		res.Status = "udp_error"
+		res.ErrorReason = FailureResponseMismatch
+		return res, nil
+	}
+	res.Status = "transport_error"
+	res.ErrorReason = classifyTransportError(lastErr)
+	return res, lastErr
+}
+
+var errResponseMismatch = errors.New("reply does not match")
+
+// attempt sends the payload once and waits for a matching reply. Replies
+// that do not match are skipped until the attempt times out.
+func (p *udpProber) attempt(conn net.Conn, buf []byte) (time.Duration, error) {
+	start := time.Now()
+	conn.SetDeadline(start.Add(p.timeout))
+
+	if _, err := conn.Write(p.payload); err != nil {
+		return 0, err
+	}
+
+	mismatched := false
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			var netErr net.Error
+			if mismatched && errors.As(err, &netErr) && netErr.Timeout() {
+				return 0, errResponseMismatch
+			}
+			return 0, err
+		}
+		if p.expect == nil || p.expect.Match(buf[:n]) {
+			return time.Since(start), nil
+		}
+		mismatched = true
+	}
+}
@@ -0,0 +1,79 @@
+"use strict";
+
+const tbody = document.getElementById("targets");
+const summary = document.getElementById("summary");
+
+function sparkline(values, width = 120, height = 24) {
+  const svg = document.createElementNS("http://www.w3.org/2000/svg", "svg");
+  svg.setAttribute("width", width);
+  svg.setAttribute("height", height);
+  if (values.length < 2) {
+    return svg;
+  }
+
+  const max = Math.max(...values) || 1;
+  const step = width / (values.length - 1);
+  const points = values.map((v, i) =>
+    `${(i * step).toFixed(1)},${(height - (v / max) * (height - 2) - 1).toFixed(1)}`);
+
+  const line = document.createElementNS("http://www.w3.org/2000/svg", "polyline");
+  line.setAttribute("points", points.join(" "));
+  svg.appendChild(line);
+  return svg;
+}
+
+function cell(row, className) {
+  const td = row.insertCell();
+  if (className) {
+    td.className = className;
+  }
+  return td;
+}
+
+function render(entries) {
+  tbody.replaceChildren();
+  let up = 0;
+
+  for (const e of entries) {
+    if (e.up) {
+      up++;
+    }
+    const row = tbody.insertRow();
+
+    const state = document.createElement("span");
+    state.className = "state" + (e.known ? (e.up ? " up" : " down") : "");
+    state.title = e.known ? (e.up ? "up" : "down") : "no data";
+    cell(row).appendChild(state);
+
+    const target = cell(row, "target");
+    target.textContent = e.target;
+    if (e.labels) {
+      const labels = document.createElement("div");
+      labels.className = "labels";
+      labels.textContent = Object.entries(e.labels).map(([k, v]) => `${k}=${v}`).join(" ");
+      target.appendChild(labels);
+    }
+
+    const latency = cell(row);
+    const wrap = document.createElement("div");
+    wrap.className = "latency";
+    wrap.appendChild(sparkline(e.history));
+    wrap.append(`${(e.latency * 1000).toFixed(0)} ms`);
+    latency.appendChild(wrap);
+
+    cell(row).textContent = `${(e.uptime * 100).toFixed(2)}%`;
+
+    const failures = cell(row, "failures");
+    failures.textContent = e.failures
+      .map(f => `${new Date(f.time).toLocaleTimeString()} ${f.reason}`)
+      .join(", ");
+  }
+
+  summary.textContent = `${up}/${entries.length} up, updated ${new Date().toLocaleTimeString()}`;
+}
+
+const events = new EventSource("events");
+events.addEventListener("status", ev => render(JSON.parse(ev.data)));
+events.onerror = () => {
+  summary.textContent = "Disconnected, retrying…";
+};
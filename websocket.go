@@ -0,0 +1,151 @@
+// Copyright (c) 2025 Dakhil Y.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"regexp"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// Steps of a WebSocket probe.
+const (
+	StepHandshake = "handshake"
+	StepMessage   = "message"
+)
+
+// WebSocketOptions configures a ws:// or wss:// target. After the upgrade
+// handshake the probe sends Message, if set, and waits for a reply matching
+// the Expect regular expression, if set. With Expect alone it waits for the
+// first message the server pushes.
+type WebSocketOptions struct {
+	Message      string            `yaml:"message" json:"message,omitempty"`
+	Expect       string            `yaml:"expect" json:"expect,omitempty"`
+	Subprotocols []string          `yaml:"subprotocols" json:"subprotocols,omitempty"`
+	Headers      map[string]string `yaml:"headers" json:"headers,omitempty"`
+}
+
+func (o *WebSocketOptions) validate() error {
+	if o == nil {
+		return nil
+	}
+	if _, err := regexp.Compile(o.Expect); err != nil {
+		return fmt.Errorf("invalid websocket.expect: %w", err)
+	}
+	return nil
+}
+
+type webSocketProber struct {
+	target Target
+	opts   WebSocketOptions
+	expect *regexp.Regexp
+	client *http.Client
+	header http.Header
+}
+
+func newWebSocketProber(t Target) (probeFunc, func()) {
+	p := &webSocketProber{target: t, header: http.Header{}}
+	if t.WebSocket != nil {
+		p.opts = *t.WebSocket
+	}
+	if p.opts.Expect != "" {
+		p.expect = regexp.MustCompile(p.opts.Expect)
+	}
+	for k, v := range p.opts.Headers {
+		p.header.Set(k, v)
+	}
+
+	// the probe deadline covers the message exchange as well, so it is set
+	// on the context instead of the client
+	client := newHTTPClient(t)
+	p.client = &http.Client{Transport: client.Transport}
+
+	if client == httpClient {
+		return p.probe, func() {}
+	}
+	return p.probe, client.CloseIdleConnections
+}
+
+func (p *webSocketProber) probe(ctx context.Context) (Result, error) {
+	var tracer phaseTracer
+	ctx = httptrace.WithClientTrace(ctx, tracer.trace())
+	ctx, cancel := context.WithTimeout(ctx, httpClient.Timeout)
+	defer cancel()
+
+	res := Result{
+		Target:      p.target.Name,
+		Time:        time.Now(),
+		Status:      "success",
+		ErrorReason: FailureNone,
+	}
+
+	conn, resp, err := websocket.Dial(ctx, p.target.URL, &websocket.DialOptions{
+		HTTPClient:   p.client,
+		HTTPHeader:   p.header,
+		Subprotocols: p.opts.Subprotocols,
+	})
+	handshake := time.Since(res.Time)
+	res.Latency = handshake.Seconds()
+	res.Phases = tracer.phases(res.Time)
+	res.IPVersion, res.Fallback = tracer.family()
+
+	if err != nil {
+		if resp != nil && resp.StatusCode != http.StatusSwitchingProtocols {
+			res.Code = resp.StatusCode
+			res.Status = "http_error"
+			res.ErrorReason = classifyHTTPStatus(resp.StatusCode)
+			if res.ErrorReason == FailureNone {
+				res.ErrorReason = FailureWebSocketUpgrade
+			}
+			return res, nil
+		}
+		res.Status = "transport_error"
+		res.ErrorReason = classifyTransportError(err)
+		if resp != nil {
+			// switched protocols with an invalid handshake
+			res.ErrorReason = FailureWebSocketUpgrade
+		}
+		return res, err
+	}
+	defer conn.Close(websocket.StatusNormalClosure, "")
+
+	res.Code = resp.StatusCode
+	res.Steps = append(res.Steps, Step{Name: StepHandshake, Latency: handshake.Seconds()})
+
+	if p.opts.Message == "" && p.expect == nil {
+		return res, nil
+	}
+
+	start := time.Now()
+	if p.opts.Message != "" {
+		err = conn.Write(ctx, websocket.MessageText, []byte(p.opts.Message))
+	}
+	var reply []byte
+	if err == nil && p.expect != nil {
+		_, reply, err = conn.Read(ctx)
+	}
+	roundTrip := time.Since(start)
+	res.Latency = time.Since(res.Time).Seconds()
+
+	if err != nil {
+		res.Status = "transport_error"
+		res.ErrorReason = classifyTransportError(err)
+		var closeErr websocket.CloseError
+		if errors.As(err, &closeErr) {
+			res.ErrorReason = FailureWebSocketClosed
+		}
+		return res, err
+	}
+
+	res.Steps = append(res.Steps, Step{Name: StepMessage, Latency: roundTrip.Seconds()})
+	if p.expect != nil && !p.expect.Match(reply) {
+		res.Status = "websocket_error"
+		res.ErrorReason = FailureResponseMismatch
+	}
+	return res, nil
+}